@@ -19,7 +19,17 @@ func CreateRoutes() {
 	http.Handle(route("/attachment/", controllers.Attachment))
 
 	http.Handle(route("/email/view/", controllers.EmailView))
+	http.Handle(route("/email/original/", controllers.EmailOriginal))
+	http.Handle(route("/email/respond/", controllers.EmailRespond))
+	http.Handle(route("/email/remote/", controllers.EmailRemoteContent))
 	http.Handle(route("/email/", controllers.Email))
+
+	http.Handle(route("/quarantine/view/", controllers.QuarantineView))
+	http.Handle(route("/quarantine/reparse/", controllers.QuarantineReparse))
+	http.Handle(route("/quarantine/discard/", controllers.QuarantineDiscard))
+	http.Handle(route("/quarantine/", controllers.Quarantine))
+
+	http.Handle(route("/inbound/sns/", controllers.InboundSNS))
 }
 
 // Quick wrapper for StripPrefix which prevents typos
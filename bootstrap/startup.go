@@ -1,19 +1,30 @@
 package bootstrap
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/anishmgoyal/calagora-admin/constants"
 	"github.com/anishmgoyal/calagora-admin/controllers"
 	"github.com/anishmgoyal/calagora-admin/models"
 	"github.com/anishmgoyal/calagora-admin/services"
+	"github.com/anishmgoyal/calagora-admin/sources"
+	"github.com/anishmgoyal/calagora-admin/utils"
 )
 
+// shutdownTimeout is how long requests and mail sessions are given to finish
+// on shutdown
+const shutdownTimeout = 30 * time.Second
+
 // GlobalStart begins initialization for the application,
 // and notifies main() if an error occurrs.
 func GlobalStart() bool {
@@ -31,8 +42,9 @@ func GlobalStart() bool {
 	db := GetDatabaseConnection()
 
 	fmt.Println("[STARTUP] Initializing Services")
+	utils.BaseInitialization()
 	controllers.BaseInitialization(templates, db)
-	services.BaseInitialization(db)
+	services.BaseInitialization(db, sources.Default())
 
 	fmt.Println("[STARTUP] Creating Routes")
 	CreateRoutes()
@@ -47,6 +59,46 @@ func GlobalStart() bool {
 		user.Create(db)
 	}
 
+	route, err := models.GetMailRouteByAddress(db, constants.SupportEmail)
+	if route == nil && err == nil {
+		route := models.MailRoute{
+			Address: constants.SupportEmail,
+			Mailbox: constants.SupportMailbox,
+		}
+		route.Create(db)
+	}
+	services.AssignLegacyMailboxes()
+
+	fmt.Println("[STARTUP] Starting mail scheduler")
+	ctx, cancel := context.WithCancel(context.Background())
+	schedulerDone := make(chan struct{})
+	go func() {
+		services.RunScheduler(ctx)
+		close(schedulerDone)
+	}()
+
+	if constants.InboundSMTPEnable {
+		fmt.Println("[STARTUP] Accepting mail over SMTP on port " +
+			strconv.Itoa(constants.InboundSMTPPortNum))
+
+		go func() {
+			err := services.ListenForSMTP(":" +
+				strconv.Itoa(constants.InboundSMTPPortNum))
+			fmt.Println("[SMTP] Listener stopped: " + err.Error())
+		}()
+	}
+
+	if constants.InboundLMTPEnable {
+		fmt.Println("[STARTUP] Accepting mail over LMTP on " +
+			constants.InboundLMTPNetwork + " " + constants.InboundLMTPAddress)
+
+		go func() {
+			err := services.ListenForLMTP(constants.InboundLMTPNetwork,
+				constants.InboundLMTPAddress)
+			fmt.Println("[LMTP] Listener stopped: " + err.Error())
+		}()
+	}
+
 	var sslRedirect = func(w http.ResponseWriter, r *http.Request) {
 		host := r.Host
 		if index := strings.Index(host, ":"); index > -1 {
@@ -59,24 +111,71 @@ func GlobalStart() bool {
 		http.Redirect(w, r, redirectURL, http.StatusMovedPermanently)
 	}
 
+	server := &http.Server{}
+	redirectServer := &http.Server{
+		Addr:    ":" + strconv.Itoa(constants.PortNum),
+		Handler: http.HandlerFunc(sslRedirect),
+	}
+
+	// Stop accepting requests and mail on shutdown, and let requests, mail
+	// sessions and the scheduler finish
+	shutdownDone := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+
+		fmt.Println("[SHUTDOWN] Shutting down")
+		cancel()
+		drainCtx, drainCancel := context.WithTimeout(context.Background(),
+			shutdownTimeout)
+		defer drainCancel()
+
+		var wg sync.WaitGroup
+		for _, shutdown := range []func(context.Context) error{
+			server.Shutdown,
+			redirectServer.Shutdown,
+			services.ShutdownMailListeners,
+		} {
+			wg.Add(1)
+			go func(shutdown func(context.Context) error) {
+				defer wg.Done()
+				if err := shutdown(drainCtx); err != nil {
+					fmt.Println("[SHUTDOWN] " + err.Error())
+				}
+			}(shutdown)
+		}
+		wg.Wait()
+		close(shutdownDone)
+	}()
+
 	if constants.SSLEnable {
 		fmt.Println("[STARTUP] Starting server on port " +
 			strconv.Itoa(constants.SSLPortNum))
 		fmt.Println("[STARTUP] Using redirect from port " +
 			strconv.Itoa(constants.PortNum))
 
-		go http.ListenAndServe(":"+strconv.Itoa(constants.PortNum),
-			http.HandlerFunc(sslRedirect))
-		http.ListenAndServeTLS(":"+strconv.Itoa(constants.SSLPortNum),
-			constants.SSLCertificate, constants.SSLKeyFile, nil)
+		go redirectServer.ListenAndServe()
+		server.Addr = ":" + strconv.Itoa(constants.SSLPortNum)
+		err = server.ListenAndServeTLS(constants.SSLCertificate,
+			constants.SSLKeyFile)
 	} else {
 		fmt.Println("[STARTUP] Starting server on port " +
 			strconv.Itoa(constants.PortNum))
 
-		http.ListenAndServe(":"+strconv.Itoa(constants.PortNum), nil)
+		server.Addr = ":" + strconv.Itoa(constants.PortNum)
+		err = server.ListenAndServe()
+	}
+
+	if err == http.ErrServerClosed {
+		// ListenAndServe returns as soon as shutdown starts
+		<-shutdownDone
+		<-schedulerDone
+		fmt.Println("[SHUTDOWN] Finished")
+		return true
 	}
 
-	// Shouldn't return, I don't believe..
-	fmt.Println("[STARTUP] Startup failed.")
+	fmt.Println("[STARTUP] Startup failed: " + err.Error())
+	cancel()
 	return false
 }
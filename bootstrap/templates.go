@@ -14,6 +14,10 @@ func GetTemplates() map[string]*template.Template {
 
 	templates["email#index"] = loadTemplate("views/email/index.html")
 	templates["email#view"] = loadTemplate("views/email/view.html")
+	templates["email#original"] = loadTemplate("views/email/original.html")
+
+	templates["quarantine#index"] = loadTemplate("views/quarantine/index.html")
+	templates["quarantine#view"] = loadTemplate("views/quarantine/view.html")
 
 	return templates
 }
@@ -45,6 +45,108 @@ var S3Bucket = "calagora-email"
 // S3RegionString is the region in which we are using S3
 var S3RegionString = "us-east-1"
 
+// MailSourceType selects where inbound mail is collected from, either
+// "s3" or "directory"
+var MailSourceType = "s3"
+
+// MailDirectory is the path to inbound mail when using the directory source
+var MailDirectory = "mail"
+
+// FileDirectory is where original messages and attachments are stored when
+// using the directory source
+var FileDirectory = "files"
+
+// TempDirectory holds attachments while they're being stored
+var TempDirectory = "tmp"
+
+// MaxBodySizeMB is the largest text body or attached email read, in
+// megabytes. Longer parts are truncated
+var MaxBodySizeMB = 25
+
+// MaxAttachmentSizeMB is the largest attachment stored, in megabytes. Longer
+// attachments are truncated
+var MaxAttachmentSizeMB = 25
+
+// MaxNestedEmails limits how deeply attached emails are parsed. Any deeper
+// are kept as attachments
+var MaxNestedEmails = 5
+
+// IngestWorkers is the most messages downloaded, parsed and saved at once
+var IngestWorkers = 16
+
+// IngestMailboxWorkers is the most messages ingested at once for one mailbox
+var IngestMailboxWorkers = 4
+
+// IngestInterval is the number of seconds between polls of the mail source
+var IngestInterval = 15
+
+// IngestJitter is the most seconds randomly added to each poll interval
+var IngestJitter = 5
+
+// InboundSMTPEnable determines whether the server accepts mail over SMTP
+var InboundSMTPEnable = false
+
+// InboundSMTPPortNum is the port on which inbound SMTP is handled if enabled
+var InboundSMTPPortNum = 2525
+
+// InboundSMTPHostname is the name the SMTP server greets clients with
+var InboundSMTPHostname = "localhost"
+
+// InboundSMTPDomains is a comma separated list of domains to accept mail for
+var InboundSMTPDomains = "calagora.com"
+
+// InboundSMTPMaxSize is the largest message, in bytes, accepted over SMTP
+var InboundSMTPMaxSize = 1024 * 1024 * 30
+
+// InboundLMTPEnable determines whether the server accepts mail over LMTP
+var InboundLMTPEnable = false
+
+// InboundLMTPNetwork is the network LMTP listens on, either "tcp" or "unix"
+var InboundLMTPNetwork = "tcp"
+
+// InboundLMTPAddress is the address or socket path LMTP listens on
+var InboundLMTPAddress = "localhost:2424"
+
+// SNSCertificate is the path to the certificate used to verify inbound mail
+// notifications from SNS. The notification endpoint is disabled if empty
+var SNSCertificate = ""
+
+// SNSTopicArn restricts inbound mail notifications to one SNS topic, if set
+var SNSTopicArn = ""
+
+// AuthServIDs is a comma separated list of the servers whose
+// Authentication-Results headers are trusted. Only the topmost header is
+// read, and if empty, it's trusted whichever server added it
+var AuthServIDs = "amazonses.com"
+
+// HTMLPolicy is the policy email HTML is sanitized with when shown. "ugc"
+// keeps formatting, links, lists, tables and images, while "strict" removes
+// all markup
+var HTMLPolicy = "ugc"
+
+// HTMLAllowStyles decides if inline styles are kept in email HTML. Styles
+// which load remote content are removed regardless
+var HTMLAllowStyles = true
+
+// SMIMETrustStore is a PEM file of the certificates trusted to issue S/MIME
+// signing certificates. If empty, the system's certificates are trusted
+var SMIMETrustStore = ""
+
+// SMIMECertificate is a PEM file of the certificate mail is encrypted to,
+// usually that of the support mailbox
+var SMIMECertificate = ""
+
+// SMIMEKey is a PEM file of the private key for SMIMECertificate, which
+// encrypted mail is decrypted with
+var SMIMEKey = ""
+
+// DKIMVerifyEnable decides if DKIM signatures are checked locally for mail
+// which arrives without a DKIM verdict, e.g. from SMTP or a directory
+var DKIMVerifyEnable = true
+
+// DKIMLookupTimeout is how long to wait on DNS for a DKIM key, in seconds
+var DKIMLookupTimeout = 5
+
 // SMTPHostname is the server which handles sending emails
 var SMTPHostname = ""
 
@@ -77,6 +179,39 @@ func LoadEnvironmentSettings() {
 	loadStringSetting(&S3Bucket, "CALAGORA_S3_BUCKET")
 	loadStringSetting(&S3RegionString, "CALAGORA_S3_REGION")
 
+	loadStringSetting(&MailSourceType, "CALAGORA_MAIL_SOURCE")
+	loadStringSetting(&MailDirectory, "CALAGORA_MAIL_DIR")
+	loadStringSetting(&FileDirectory, "CALAGORA_FILE_DIR")
+	loadStringSetting(&TempDirectory, "CALAGORA_TEMP_DIR")
+	loadIntSetting(&MaxBodySizeMB, "CALAGORA_MAX_BODY_SIZE_MB")
+	loadIntSetting(&MaxAttachmentSizeMB, "CALAGORA_MAX_ATTACHMENT_SIZE_MB")
+	loadIntSetting(&MaxNestedEmails, "CALAGORA_MAX_NESTED_EMAILS")
+	loadIntSetting(&IngestWorkers, "CALAGORA_INGEST_WORKERS")
+	loadIntSetting(&IngestMailboxWorkers, "CALAGORA_INGEST_MAILBOX_WORKERS")
+	loadIntSetting(&IngestInterval, "CALAGORA_INGEST_INTERVAL")
+	loadIntSetting(&IngestJitter, "CALAGORA_INGEST_JITTER")
+
+	loadBooleanSetting(&InboundSMTPEnable, "CALAGORA_INBOUND_SMTP_ENABLE")
+	loadIntSetting(&InboundSMTPPortNum, "CALAGORA_INBOUND_SMTP_PORT")
+	loadStringSetting(&InboundSMTPHostname, "CALAGORA_INBOUND_SMTP_HOST")
+	loadStringSetting(&InboundSMTPDomains, "CALAGORA_INBOUND_SMTP_DOMAINS")
+	loadIntSetting(&InboundSMTPMaxSize, "CALAGORA_INBOUND_SMTP_MAX_SIZE")
+
+	loadBooleanSetting(&InboundLMTPEnable, "CALAGORA_LMTP_ENABLE")
+	loadStringSetting(&InboundLMTPNetwork, "CALAGORA_LMTP_NETWORK")
+	loadStringSetting(&InboundLMTPAddress, "CALAGORA_LMTP_ADDRESS")
+
+	loadStringSetting(&SNSCertificate, "CALAGORA_SNS_CERTIFICATE")
+	loadStringSetting(&SNSTopicArn, "CALAGORA_SNS_TOPIC_ARN")
+	loadStringSetting(&AuthServIDs, "CALAGORA_AUTHSERV_IDS")
+	loadStringSetting(&HTMLPolicy, "CALAGORA_HTML_POLICY")
+	loadBooleanSetting(&HTMLAllowStyles, "CALAGORA_HTML_ALLOW_STYLES")
+	loadStringSetting(&SMIMETrustStore, "CALAGORA_SMIME_TRUST_STORE")
+	loadStringSetting(&SMIMECertificate, "CALAGORA_SMIME_CERTIFICATE")
+	loadStringSetting(&SMIMEKey, "CALAGORA_SMIME_KEY")
+	loadBooleanSetting(&DKIMVerifyEnable, "CALAGORA_DKIM_VERIFY")
+	loadIntSetting(&DKIMLookupTimeout, "CALAGORA_DKIM_LOOKUP_TIMEOUT")
+
 	loadStringSetting(&SMTPHostname, "CALAGORA_SMTP_HOST")
 	loadStringSetting(&SMTPPort, "CALAGORA_SMTP_PORT")
 	loadStringSetting(&SMTPAuthUser, "CALAGORA_SMTP_USER")
@@ -3,4 +3,6 @@ package constants
 const (
 	// SupportEmail is the support email address for calagora
 	SupportEmail = "support@calagora.com"
+	// SupportMailbox is the name of the shared mailbox for support email
+	SupportMailbox = "support"
 )
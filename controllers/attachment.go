@@ -1,10 +1,10 @@
 package controllers
 
 import (
+	"mime"
 	"net/http"
 	"strconv"
 
-	"github.com/anishmgoyal/calagora-admin/constants"
 	"github.com/anishmgoyal/calagora-admin/models"
 	"github.com/anishmgoyal/calagora-admin/utils"
 )
@@ -52,23 +52,13 @@ func Attachment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	supportUser := models.User{EmailAddress: constants.SupportEmail}
-
-	ok, err := user.IsRecipient(Base.Db, attachment.EmailID)
+	email, err := models.GetEmailByID(Base.Db, attachment.EmailID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if !ok {
-		ok, err = supportUser.IsRecipient(Base.Db, attachment.EmailID)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-	}
-
-	if !ok {
+	if email == nil || !canAccessMailbox(user, email.Mailbox) {
 		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
@@ -79,6 +69,18 @@ func Attachment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Attachments are the sender's content, so they mustn't run as a page
+	// here. Only images safe to show inline are shown rather than downloaded
 	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	if !utils.IsInlineImage(attachment.ContentType) {
+		disposition := mime.FormatMediaType("attachment",
+			map[string]string{"filename": attachment.FileName})
+		if len(disposition) == 0 {
+			disposition = "attachment"
+		}
+		w.Header().Set("Content-Disposition", disposition)
+	}
 	w.Write(body)
 }
@@ -31,6 +31,7 @@ func BaseInitialization(templates map[string]*template.Template, db *sql.DB) {
 	Base.Templates = templates
 	Base.Db = db
 	emailInit()
+	inboundInit()
 }
 
 // BaseViewData populates view data based on the request and response writer
@@ -1,11 +1,15 @@
 package controllers
 
 import (
+	"fmt"
+	"html/template"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"github.com/anishmgoyal/calagora-admin/models"
+	"github.com/anishmgoyal/calagora-admin/services"
+	"github.com/anishmgoyal/calagora-admin/utils"
 )
 
 type emailViewData struct {
@@ -13,8 +17,9 @@ type emailViewData struct {
 	CurrentAddress   string
 	ActiveSelector   string
 	Page             int
-	SwitchToName     string
-	SwitchToHref     string
+	SwitchTo         []accountLink
+	FilterHeader     string
+	FilterValue      string
 	Emails           []models.Email
 }
 
@@ -26,10 +31,13 @@ const (
 	numPages
 )
 
-const (
-	supportEmail = "support@calagora.com"
-	emailSuffix  = "@calagora.com"
-)
+const emailSuffix = "@calagora.com"
+
+// accountLink links to a mailbox the user can switch to
+type accountLink struct {
+	Address string
+	Href    string
+}
 
 var selectors []string
 
@@ -77,6 +85,8 @@ func Email(w http.ResponseWriter, r *http.Request) {
 	data := &emailViewData{
 		EmailAccountName: args[0],
 		Page:             getPage(args[1]),
+		FilterHeader:     strings.TrimSpace(r.URL.Query().Get("header")),
+		FilterValue:      r.URL.Query().Get("value"),
 	}
 	data.ActiveSelector = selectors[data.Page]
 
@@ -85,35 +95,25 @@ func Email(w http.ResponseWriter, r *http.Request) {
 		user = nil
 	}
 
-	emailUser := models.User{}
+	if user == nil {
+		http.Error(w, "Internal Error", http.StatusInternalServerError)
+		return
+	}
 
-	if strings.Compare(args[0], "mine") == 0 {
-		// Render my email account
-		if user != nil {
-			data.CurrentAddress = user.Username + emailSuffix
-			emailUser.EmailAddress = user.EmailAddress
-		} else {
-			http.Error(w, "Internal Error", http.StatusInternalServerError)
-			return
-		}
-		data.SwitchToName = supportEmail
-		data.SwitchToHref = "/email/support/"
-	} else if strings.Compare(args[0], "support") == 0 {
-		// Render support email account
-		data.CurrentAddress = supportEmail
-		emailUser.EmailAddress = supportEmail
-		if user != nil {
-			data.SwitchToName = user.Username + emailSuffix
-		} else {
-			data.SwitchToName = "Your Email"
-		}
-		data.SwitchToHref = "/email/mine/"
-	} else {
+	mailbox, ok := accountMailbox(user, args[0])
+	if !ok {
 		http.Error(w, "Not Found", http.StatusNotFound)
 		return
 	}
+	data.CurrentAddress, data.SwitchTo = accountNavigation(user, mailbox)
 
-	emails, err := emailUser.LoadEmailPage(Base.Db, 0)
+	var emails []models.Email
+	if len(data.FilterHeader) > 0 {
+		emails, err = models.LoadEmailsWithHeader(Base.Db, mailbox,
+			data.FilterHeader, data.FilterValue, 0)
+	} else {
+		emails, err = models.LoadEmailsForMailbox(Base.Db, mailbox, 0)
+	}
 	if err == nil {
 		data.Emails = emails
 	} else {
@@ -126,12 +126,17 @@ func Email(w http.ResponseWriter, r *http.Request) {
 }
 
 type emailViewViewData struct {
-	Email            models.Email
-	EmailAccountName string
-	CurrentAddress   string
-	SwitchToName     string
-	SwitchToHref     string
-	Emails           []models.Email
+	Email                models.Email
+	Body                 template.HTML
+	PlainBody            template.HTML
+	RemoteContentBlocked bool
+	SenderAuthenticated  bool
+	Attachments          []models.Attachment
+	AttachedEmails       []attachedEmailViewData
+	EmailAccountName     string
+	CurrentAddress       string
+	SwitchTo             []accountLink
+	Emails               []models.Email
 }
 
 // EmailView renders the route '/email/view/#id'
@@ -158,59 +163,419 @@ func EmailView(w http.ResponseWriter, r *http.Request) {
 		EmailAccountName: args[0],
 	}
 
-	idStr := args[1]
-	id, err := strconv.Atoi(idStr)
-	if err != nil {
+	email := authorizedEmail(w, user, args[1])
+	if email == nil {
+		return
+	}
+
+	mailbox, ok := accountMailbox(user, args[0])
+	if !ok {
 		http.Error(w, "Not Found", http.StatusNotFound)
 		return
 	}
+	data.CurrentAddress, data.SwitchTo = accountNavigation(user, mailbox)
+
+	// Remote content is loaded if allowed for this email or its sender. The
+	// sender is only taken at its word if it passed DMARC
+	data.SenderAuthenticated = senderAuthenticated(email)
+	allowRemote := email.LoadRemoteContent
+	if !allowRemote && data.SenderAuthenticated {
+		allowRemote, err = models.IsRemoteContentAllowed(Base.Db,
+			email.Mailbox, email.From)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 
-	email, err := models.GetEmailByID(Base.Db, id)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	data.Email = *email
+	data.Body, data.RemoteContentBlocked = emailBody(email, allowRemote)
+	data.PlainBody = utils.LinkifyText(email.PlainText)
+	data.Attachments = listedAttachments(email)
+	data.AttachedEmails = attachedEmailViews(email.Children, args[0],
+		allowRemote)
+	for _, attached := range data.AttachedEmails {
+		data.RemoteContentBlocked = data.RemoteContentBlocked ||
+			attached.RemoteContentBlocked
+	}
+	viewData.Data = data
+
+	email.MarkRead(Base.Db)
+
+	RenderView(w, "email#view", viewData)
+}
+
+// attachedEmailViewData holds an email attached to the one being viewed,
+// which is rendered inside it
+type attachedEmailViewData struct {
+	Email                models.Email
+	Body                 template.HTML
+	PlainBody            template.HTML
+	RemoteContentBlocked bool
+	Attachments          []models.Attachment
+	AttachedEmails       []attachedEmailViewData
+	EmailAccountName     string
+}
+
+// attachedEmailViews builds the views of attached emails, which load remote
+// content only if the email they're attached to does
+func attachedEmailViews(emails []models.Email, accountName string,
+	allowRemote bool) []attachedEmailViewData {
+
+	views := make([]attachedEmailViewData, 0, len(emails))
+	for _, email := range emails {
+		view := attachedEmailViewData{
+			Email:       email,
+			PlainBody:   utils.LinkifyText(email.PlainText),
+			Attachments: listedAttachments(&email),
+			AttachedEmails: attachedEmailViews(email.Children, accountName,
+				allowRemote),
+			EmailAccountName: accountName,
+		}
+		view.Body, view.RemoteContentBlocked = emailBody(&email, allowRemote)
+		for _, attached := range view.AttachedEmails {
+			view.RemoteContentBlocked = view.RemoteContentBlocked ||
+				attached.RemoteContentBlocked
+		}
+		views = append(views, view)
+	}
+	return views
+}
+
+// emailBody sanitizes the HTML body of an email for display, with inline
+// images linked to their attachments. Also determines if remote content was
+// blocked
+func emailBody(email *models.Email, allowRemote bool) (template.HTML, bool) {
+	if len(email.FormattedText) == 0 {
+		return "", false
+	}
+	return utils.SanitizeHTML(utils.RewriteContentIDs(email.FormattedText,
+		email.Attachments), allowRemote)
+}
+
+// listedAttachments finds the attachments of an email which should be listed.
+// Inline attachments are shown in the body instead
+func listedAttachments(email *models.Email) []models.Attachment {
+	attachments := make([]models.Attachment, 0, len(email.Attachments))
+	for _, attachment := range email.Attachments {
+		if !attachment.IsInline {
+			attachments = append(attachments, attachment)
+		}
+	}
+	return attachments
+}
+
+type emailOriginalViewData struct {
+	Email            models.Email
+	EmailAccountName string
+	Headers          string
+	Source           string
+	Error            string
+}
+
+// EmailOriginal renders the route '/email/original/#account/#id', showing
+// the original source of an email. Adding '/download' to the route sends
+// the source as a .eml file instead
+func EmailOriginal(w http.ResponseWriter, r *http.Request) {
+	viewData := BaseViewData(w, r)
+	if viewData.Session == nil {
+		http.Redirect(w, r, "/", http.StatusFound)
 		return
 	}
 
-	if email == nil {
+	user, err := models.GetUserByID(Base.Db, viewData.Session.UserID)
+	if err != nil || user == nil {
+		http.Error(w, "Error", http.StatusNotFound)
+		return
+	}
+
+	args := URIArgs(r)
+	if len(args) < 2 {
 		http.Error(w, "Not Found", http.StatusNotFound)
 		return
 	}
 
-	if strings.Compare(args[0], "mine") == 0 {
-		data.CurrentAddress = user.Username + emailSuffix
-		data.SwitchToHref = "/email/support/"
-		data.SwitchToName = supportEmail
-	} else {
-		data.CurrentAddress = supportEmail
-		data.SwitchToHref = "/email/mine/"
-		data.SwitchToName = user.Username + emailSuffix
+	email := authorizedEmail(w, user, args[1])
+	if email == nil {
+		return
 	}
 
-	found := false
-	recipients := make([]string, len(email.To)+len(email.CC)+len(email.BCC))
-	offset := 0
-	for _, slice := range [][]string{email.To, email.CC, email.BCC} {
-		copy(recipients[offset:], slice)
-		offset += len(slice)
+	data := &emailOriginalViewData{
+		Email:            *email,
+		EmailAccountName: args[0],
+	}
+
+	var source []byte
+	if len(email.RawPath) == 0 {
+		data.Error = "The original source of this email was not kept."
+	} else if source, err = utils.LoadFile(email.RawPath); err != nil {
+		data.Error = err.Error()
+	}
+
+	if len(args) > 2 && strings.Compare(args[2], "download") == 0 {
+		if len(data.Error) > 0 {
+			http.Error(w, data.Error, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "message/rfc822")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"email-"+
+			strconv.Itoa(email.ID)+".eml\"")
+		w.Write(source)
+		return
 	}
 
-	for _, recipient := range recipients {
-		if strings.Compare(recipient, user.EmailAddress) == 0 ||
-			strings.Compare(recipient, "support@calagora.com") == 0 {
-			found = true
+	data.Source = string(source)
+	data.Headers = data.Source
+	for _, separator := range []string{"\r\n\r\n", "\n\n"} {
+		if idx := strings.Index(data.Source, separator); idx > -1 {
+			data.Headers = data.Source[:idx]
 			break
 		}
 	}
 
-	if !found {
-		http.Error(w, "Forbidden", http.StatusForbidden)
+	viewData.Data = data
+	RenderView(w, "email#original", viewData)
+}
+
+// EmailRespond handles the route '/email/respond/#account/#id/#event',
+// replying to a calendar invite with the posted response
+func EmailRespond(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	data.Email = *email
-	viewData.Data = data
+	viewData := BaseViewData(w, r)
+	if viewData.Session == nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
 
-	email.MarkRead(Base.Db)
+	user, err := models.GetUserByID(Base.Db, viewData.Session.UserID)
+	if err != nil || user == nil {
+		http.Error(w, "Error", http.StatusNotFound)
+		return
+	}
 
-	RenderView(w, "email#view", viewData)
+	args := URIArgs(r)
+	if len(args) < 3 {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	email := authorizedEmail(w, user, args[1])
+	if email == nil {
+		return
+	}
+
+	var event *models.CalendarEvent
+	for i := range email.Events {
+		if strconv.Itoa(email.Events[i].ID) == args[2] {
+			event = &email.Events[i]
+		}
+	}
+	if event == nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	var response string
+	switch r.FormValue("response") {
+	case "accept":
+		response = models.CalendarResponseAccepted
+	case "tentative":
+		response = models.CalendarResponseTentative
+	case "decline":
+		response = models.CalendarResponseDeclined
+	default:
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	err = services.RespondToEvent(email, event, response)
+	if err == services.ErrSendingDisabled {
+		// The response isn't recorded, as the organizer never got it
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/email/view/"+args[0]+"/"+strconv.Itoa(email.ID),
+		http.StatusFound)
+}
+
+// EmailRemoteContent handles the route '/email/remote/#account/#id', letting
+// remote content load in an email. Posting a scope of "sender" allows it for
+// all mail from the same sender to the mailbox
+func EmailRemoteContent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	viewData := BaseViewData(w, r)
+	if viewData.Session == nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	user, err := models.GetUserByID(Base.Db, viewData.Session.UserID)
+	if err != nil || user == nil {
+		http.Error(w, "Error", http.StatusNotFound)
+		return
+	}
+
+	args := URIArgs(r)
+	if len(args) < 2 {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	email := authorizedEmail(w, user, args[1])
+	if email == nil {
+		return
+	}
+
+	switch r.FormValue("scope") {
+	case "email":
+		err = email.SetLoadRemoteContent(Base.Db, true)
+	case "sender":
+		if !senderAuthenticated(email) {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		err = models.AllowRemoteContent(Base.Db, email.Mailbox, email.From)
+	default:
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/email/view/"+args[0]+"/"+strconv.Itoa(email.ID),
+		http.StatusFound)
+}
+
+// senderAuthenticated determines if an email's From address can be trusted,
+// as anyone can send mail claiming to be from an allowed sender
+func senderAuthenticated(email *models.Email) bool {
+	return !email.IsUnauthenticated &&
+		email.DMARCVerdict == models.AuthResultPass
+}
+
+// authorizedEmail loads the email with the given ID, checking that it was
+// delivered to a mailbox the user can access. Writes a response and returns
+// nil on failure
+func authorizedEmail(w http.ResponseWriter, user *models.User,
+	idStr string) *models.Email {
+
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return nil
+	}
+
+	email, err := models.GetEmailByID(Base.Db, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+
+	if email == nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return nil
+	}
+
+	if !canAccessMailbox(user, email.Mailbox) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil
+	}
+
+	return email
+}
+
+// userMailboxes lists every mailbox a user can access: their own, and each
+// shared mailbox they're a member of. Being routed mail doesn't share a
+// mailbox with anyone
+func userMailboxes(user *models.User) []string {
+	mailboxes := []string{user.Username}
+
+	shared, err := models.GetMemberMailboxes(Base.Db, user.Username)
+	if err != nil {
+		fmt.Println("[EMAIL] Failed to load shared mailboxes: " + err.Error())
+		return mailboxes
+	}
+	for _, mailbox := range shared {
+		if strings.Compare(mailbox, user.Username) != 0 {
+			mailboxes = append(mailboxes, mailbox)
+		}
+	}
+	return mailboxes
+}
+
+// accountMailbox finds the mailbox for the account name in a URL, which is
+// either "mine" or the name of a shared mailbox. Returns false if the user
+// can't access it
+func accountMailbox(user *models.User, accountName string) (string, bool) {
+	if strings.Compare(accountName, "mine") == 0 {
+		return user.Username, true
+	}
+	// A user's own mailbox is always shown as "mine"
+	if strings.Compare(accountName, user.Username) != 0 &&
+		canAccessMailbox(user, accountName) {
+		return accountName, true
+	}
+	return "", false
+}
+
+// accountNavigation gets the address of the mailbox being viewed, and links
+// to each of the user's other mailboxes
+func accountNavigation(user *models.User,
+	current string) (string, []accountLink) {
+
+	var address string
+	links := make([]accountLink, 0, 2)
+	for _, mailbox := range userMailboxes(user) {
+		link := accountLink{
+			Address: mailboxAddress(user, mailbox),
+			Href:    "/email/" + mailbox + "/",
+		}
+		if strings.Compare(mailbox, user.Username) == 0 {
+			link.Href = "/email/mine/"
+		}
+		if strings.Compare(mailbox, current) == 0 {
+			address = link.Address
+		} else {
+			links = append(links, link)
+		}
+	}
+	return address, links
+}
+
+// mailboxAddress gets the address shown for a mailbox, from the routing table
+// where it has one
+func mailboxAddress(user *models.User, mailbox string) string {
+	if strings.Compare(mailbox, user.Username) == 0 {
+		return user.Username + emailSuffix
+	}
+	address, err := models.GetMailboxAddress(Base.Db, mailbox)
+	if err != nil || len(address) == 0 {
+		return mailbox + emailSuffix
+	}
+	return address
+}
+
+// canAccessMailbox determines if a user can read mail in a mailbox
+func canAccessMailbox(user *models.User, mailbox string) bool {
+	for _, accessible := range userMailboxes(user) {
+		if len(mailbox) > 0 && strings.Compare(mailbox, accessible) == 0 {
+			return true
+		}
+	}
+	return false
 }
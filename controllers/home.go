@@ -54,7 +54,7 @@ func postHome(w http.ResponseWriter, r *http.Request) {
 
 	if user.Authenticate(password) {
 		services.AddSession(user, w, r)
-		go services.DownloadEmailForUser(user.Username, make(chan string))
+		services.RequestPoll()
 
 		viewData.Data = &homeData{
 			Error: false,
@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/anishmgoyal/calagora-admin/constants"
+	"github.com/anishmgoyal/calagora-admin/services"
+	"github.com/anishmgoyal/calagora-admin/utils"
+)
+
+const maxSNSMessageSize = 1024 * 256
+
+var snsCertificate *x509.Certificate
+
+func inboundInit() {
+	if len(constants.SNSCertificate) == 0 {
+		return
+	}
+	cert, err := utils.LoadCertificate(constants.SNSCertificate)
+	if err != nil {
+		fmt.Println("[SNS] Notifications disabled: " + err.Error())
+		return
+	}
+	snsCertificate = cert
+}
+
+// InboundSNS handles the route '/inbound/sns/', which receives notifications
+// from SNS when SES stores inbound mail in S3
+func InboundSNS(w http.ResponseWriter, r *http.Request) {
+	if snsCertificate == nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var message utils.SNSMessage
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body,
+		maxSNSMessageSize))
+	if err := decoder.Decode(&message); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	if err := utils.VerifySNSMessage(&message, snsCertificate); err != nil {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if len(constants.SNSTopicArn) > 0 &&
+		strings.Compare(message.TopicArn, constants.SNSTopicArn) != 0 {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	switch message.Type {
+	case "SubscriptionConfirmation":
+		response, err := http.Get(message.SubscribeURL)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		response.Body.Close()
+	case "Notification":
+		var notification utils.SESNotification
+		err := json.Unmarshal([]byte(message.Message), &notification)
+		if err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		if strings.Compare(notification.NotificationType, "Received") != 0 ||
+			strings.Compare(notification.Receipt.Action.Type, "S3") != 0 {
+			// Nothing for us to collect
+			break
+		}
+		if strings.Compare(notification.Receipt.Action.BucketName,
+			constants.S3Bucket) != 0 {
+			http.Error(w, "Unknown Bucket", http.StatusBadRequest)
+			return
+		}
+		err = services.IngestObject(notification.Receipt.Action.ObjectKey,
+			notification.Receipt.Recipients)
+		if err != nil {
+			// SNS will retry the notification later
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/anishmgoyal/calagora-admin/models"
+	"github.com/anishmgoyal/calagora-admin/services"
+	"github.com/anishmgoyal/calagora-admin/utils"
+)
+
+type quarantineViewData struct {
+	Messages []models.QuarantinedMessage
+}
+
+type quarantineViewViewData struct {
+	Message models.QuarantinedMessage
+	Source  string
+	Error   string
+}
+
+// Quarantine handles the route '/quarantine/'
+func Quarantine(w http.ResponseWriter, r *http.Request) {
+	viewData := BaseViewData(w, r)
+	if viewData.Session == nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	user, err := models.GetUserByID(Base.Db, viewData.Session.UserID)
+	if err != nil || user == nil {
+		http.Error(w, "Error", http.StatusNotFound)
+		return
+	}
+
+	messages, err := models.LoadQuarantinedMessages(Base.Db,
+		userMailboxes(user))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	viewData.Data = &quarantineViewData{Messages: messages}
+	RenderView(w, "quarantine#index", viewData)
+}
+
+// QuarantineView handles the route '/quarantine/view/#id'
+func QuarantineView(w http.ResponseWriter, r *http.Request) {
+	viewData := BaseViewData(w, r)
+	q, ok := quarantinedMessageForRequest(w, r, viewData)
+	if !ok {
+		return
+	}
+
+	data := &quarantineViewViewData{Message: *q}
+	source, err := utils.LoadFile(q.FilePath)
+	if err != nil {
+		data.Error = err.Error()
+	} else {
+		data.Source = string(source)
+	}
+
+	viewData.Data = data
+	RenderView(w, "quarantine#view", viewData)
+}
+
+// QuarantineReparse handles the route '/quarantine/reparse/#id'
+func QuarantineReparse(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	viewData := BaseViewData(w, r)
+	q, ok := quarantinedMessageForRequest(w, r, viewData)
+	if !ok {
+		return
+	}
+
+	if err := services.ReparseQuarantined(q); err != nil {
+		http.Redirect(w, r, "/quarantine/view/"+strconv.Itoa(q.ID),
+			http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, "/quarantine/", http.StatusFound)
+}
+
+// QuarantineDiscard handles the route '/quarantine/discard/#id'
+func QuarantineDiscard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	viewData := BaseViewData(w, r)
+	q, ok := quarantinedMessageForRequest(w, r, viewData)
+	if !ok {
+		return
+	}
+
+	if err := services.DiscardQuarantined(q); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/quarantine/", http.StatusFound)
+}
+
+// quarantinedMessageForRequest loads the quarantined message named in the
+// URI, checking that the current user may access its mailbox. Writes a
+// response and returns false on failure
+func quarantinedMessageForRequest(w http.ResponseWriter, r *http.Request,
+	viewData ViewData) (*models.QuarantinedMessage, bool) {
+
+	if viewData.Session == nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return nil, false
+	}
+
+	user, err := models.GetUserByID(Base.Db, viewData.Session.UserID)
+	if err != nil || user == nil {
+		http.Error(w, "Error", http.StatusNotFound)
+		return nil, false
+	}
+
+	args := URIArgs(r)
+	if len(args) < 1 {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return nil, false
+	}
+
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return nil, false
+	}
+
+	q, err := models.GetQuarantinedMessageByID(Base.Db, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if q == nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return nil, false
+	}
+
+	if !canAccessMailbox(user, q.Mailbox) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, false
+	}
+
+	return q, true
+}
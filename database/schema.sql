@@ -8,21 +8,52 @@ CREATE TABLE admusers (
 
 CREATE TABLE emails (
   id SERIAL PRIMARY KEY,
+  parent_id INT REFERENCES emails(id) ON DELETE CASCADE,
+  mailbox VARCHAR(100) DEFAULT(''),
+  message_id VARCHAR(1000) DEFAULT(''),
+  source_hash VARCHAR(64) DEFAULT(''),
+  raw_path VARCHAR(1000) DEFAULT(''),
   from_addr VARCHAR(255),
   from_display VARCHAR(255),
   subject VARCHAR(500),
+  charset VARCHAR(100) DEFAULT(''),
   plain_text TEXT,
   formatted_text TEXT,
   is_read BOOLEAN DEFAULT(false),
   is_spam BOOLEAN DEFAULT(false),
   is_virus BOOLEAN DEFAULT(false),
+  spf_verdict VARCHAR(20) DEFAULT(''),
+  spf_domain VARCHAR(255) DEFAULT(''),
+  dkim_verdict VARCHAR(20) DEFAULT(''),
+  dkim_domain VARCHAR(255) DEFAULT(''),
+  dmarc_verdict VARCHAR(20) DEFAULT(''),
+  is_unauthenticated BOOLEAN DEFAULT(false),
+  is_encrypted BOOLEAN DEFAULT(false),
+  signature_status VARCHAR(20) DEFAULT(''),
+  signature_error VARCHAR(1000) DEFAULT(''),
+  signer VARCHAR(500) DEFAULT(''),
+  signer_issuer VARCHAR(500) DEFAULT(''),
+  signer_expires TIMESTAMP WITH TIME ZONE,
+  smime_parts TEXT DEFAULT(''),
+  load_remote_content BOOLEAN DEFAULT(false),
+  warnings TEXT DEFAULT(''),
+  sent TIMESTAMP WITH TIME ZONE,
   received TIMESTAMP WITH TIME ZONE
 );
 
+CREATE UNIQUE INDEX emails_source_hash ON emails (mailbox, source_hash)
+  WHERE parent_id IS NULL;
+CREATE UNIQUE INDEX emails_unique_message_id ON emails (mailbox, message_id)
+  WHERE message_id <> '' AND parent_id IS NULL;
+CREATE INDEX emails_message_id ON emails (mailbox, message_id);
+CREATE INDEX emails_parent_id ON emails (parent_id);
+CREATE INDEX emails_received ON emails (mailbox, received);
+
 CREATE TABLE recipients (
   email_id INT REFERENCES emails(id) ON DELETE CASCADE,
+  display_name VARCHAR(255) DEFAULT(''),
   email_address VARCHAR(255),
-  recipient_type VARCHAR(4)
+  recipient_type VARCHAR(20)
 );
 
 CREATE TABLE attachments (
@@ -30,12 +61,95 @@ CREATE TABLE attachments (
   content_type VARCHAR(255),
   file_name VARCHAR(255),
   file_path VARCHAR(1000),
+  content_id VARCHAR(1000) DEFAULT(''),
+  disposition VARCHAR(20) DEFAULT(''),
+  is_inline BOOLEAN DEFAULT(false),
+  warning VARCHAR(1000) DEFAULT(''),
   email_id INT REFERENCES emails(id) ON DELETE CASCADE
 );
 
+CREATE TABLE email_headers (
+  email_id INT REFERENCES emails(id) ON DELETE CASCADE,
+  position INT,
+  name VARCHAR(255),
+  value TEXT
+);
+
+CREATE INDEX email_headers_email_id ON email_headers (email_id, position);
+CREATE INDEX email_headers_name ON email_headers (lower(name));
+
+CREATE TABLE calendar_events (
+  id SERIAL PRIMARY KEY,
+  email_id INT REFERENCES emails(id) ON DELETE CASCADE,
+  uid VARCHAR(1000),
+  method VARCHAR(20) DEFAULT(''),
+  sequence INT DEFAULT(0),
+  status VARCHAR(20) DEFAULT(''),
+  summary VARCHAR(1000) DEFAULT(''),
+  description TEXT DEFAULT(''),
+  location VARCHAR(1000) DEFAULT(''),
+  organizer_name VARCHAR(255) DEFAULT(''),
+  organizer_address VARCHAR(255) DEFAULT(''),
+  start_time TIMESTAMP WITH TIME ZONE,
+  start_time_zone VARCHAR(100) DEFAULT(''),
+  end_time TIMESTAMP WITH TIME ZONE,
+  end_time_zone VARCHAR(100) DEFAULT(''),
+  all_day BOOLEAN DEFAULT(false),
+  response VARCHAR(20) DEFAULT('')
+);
+
+CREATE TABLE calendar_attendees (
+  event_id INT REFERENCES calendar_events(id) ON DELETE CASCADE,
+  display_name VARCHAR(255) DEFAULT(''),
+  email_address VARCHAR(255),
+  role VARCHAR(50) DEFAULT(''),
+  status VARCHAR(20) DEFAULT(''),
+  rsvp BOOLEAN DEFAULT(false)
+);
+
+CREATE TABLE mail_routes (
+  id SERIAL PRIMARY KEY,
+  address VARCHAR(255) UNIQUE,
+  mailbox VARCHAR(100)
+);
+
+-- Users who can read a shared mailbox. A username of '*' shares it with
+-- every user
+CREATE TABLE mailbox_members (
+  mailbox VARCHAR(100),
+  username VARCHAR(100),
+  PRIMARY KEY (mailbox, username)
+);
+
+INSERT INTO mailbox_members (mailbox, username) VALUES ('support', '*');
+
+CREATE TABLE remote_content_senders (
+  id SERIAL PRIMARY KEY,
+  mailbox VARCHAR(100),
+  address VARCHAR(255),
+  UNIQUE (mailbox, address)
+);
+
+CREATE TABLE quarantine (
+  id SERIAL PRIMARY KEY,
+  mailbox VARCHAR(100),
+  source_key VARCHAR(1000),
+  source_hash CHAR(64) DEFAULT(''),
+  reason TEXT,
+  file_path VARCHAR(1000),
+  created TIMESTAMP WITH TIME ZONE
+);
+
 /*
 DROP TABLE admusers;
 DROP TABLE attachments;
 DROP TABLE recipients;
+DROP TABLE email_headers;
+DROP TABLE calendar_attendees;
+DROP TABLE calendar_events;
 DROP TABLE emails;
+DROP TABLE quarantine;
+DROP TABLE mail_routes;
+DROP TABLE mailbox_members;
+DROP TABLE remote_content_senders;
 */
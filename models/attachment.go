@@ -9,15 +9,24 @@ type Attachment struct {
 	ContentType string `json:"content_type"`
 	FileName    string `json:"file_name"`
 	FilePath    string `json:"file_path"`
+	ContentID   string `json:"content_id"`
+	Disposition string `json:"disposition"`
+	IsInline    bool   `json:"is_inline"`
+	Warning     string `json:"warning"`
 	RawData     []byte `json:"-"`
-	EmailID     int    `json:"email_id"`
+	// TempPath is where the contents are kept before being stored, if not in
+	// RawData
+	TempPath string `json:"-"`
+	EmailID  int    `json:"email_id"`
 }
 
 // Create attempts to save information about an attachment to the database
 func (a *Attachment) Create(db dbInterface) error {
 	rows, err := db.Query("INSERT INTO attachments (content_type, file_name, "+
-		"file_path, email_id) VALUES ($1, $2, $3, $4) RETURNING id", a.ContentType,
-		a.FileName, a.FilePath, a.EmailID)
+		"file_path, content_id, disposition, is_inline, warning, email_id) "+
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id", a.ContentType,
+		a.FileName, a.FilePath, a.ContentID, a.Disposition, a.IsInline,
+		a.Warning, a.EmailID)
 	if err != nil {
 		return err
 	}
@@ -31,8 +40,10 @@ func (a *Attachment) Create(db dbInterface) error {
 // Save attempts to save information about an attachment to the database
 func (a *Attachment) Save(db dbInterface) error {
 	_, err := db.Exec("UPDATE attachments SET content_type = $1, "+
-		"file_name = $2, file_path = $3, email_id = $4 WHERE id = $5",
-		a.ContentType, a.FileName, a.FilePath, a.EmailID, a.ID)
+		"file_name = $2, file_path = $3, content_id = $4, disposition = $5, "+
+		"is_inline = $6, warning = $7, email_id = $8 WHERE id = $9",
+		a.ContentType, a.FileName, a.FilePath, a.ContentID, a.Disposition,
+		a.IsInline, a.Warning, a.EmailID, a.ID)
 	return err
 }
 
@@ -45,7 +56,8 @@ func (a *Attachment) Delete(db dbInterface) error {
 // GetAttachmentByID tries to find an attachment by its ID
 func GetAttachmentByID(db *sql.DB, id int) (*Attachment, error) {
 	rows, err := db.Query("SELECT id, content_type, file_name, file_path, "+
-		"email_id FROM attachments WHERE id = $1", id)
+		"content_id, disposition, is_inline, warning, email_id FROM attachments "+
+		"WHERE id = $1", id)
 	if err != nil {
 		return nil, err
 	}
@@ -54,7 +66,9 @@ func GetAttachmentByID(db *sql.DB, id int) (*Attachment, error) {
 	if rows.Next() {
 		var attachment Attachment
 		err = rows.Scan(&attachment.ID, &attachment.ContentType,
-			&attachment.FileName, &attachment.FilePath, &attachment.EmailID)
+			&attachment.FileName, &attachment.FilePath, &attachment.ContentID,
+			&attachment.Disposition, &attachment.IsInline, &attachment.Warning,
+			&attachment.EmailID)
 		if err == nil {
 			return &attachment, nil
 		}
@@ -66,8 +80,9 @@ func GetAttachmentByID(db *sql.DB, id int) (*Attachment, error) {
 // GetAttachmentsForEmail attempts to build data about attachments for
 // a given email, and insert them into the email object
 func (e *Email) GetAttachmentsForEmail(db dbInterface) error {
-	rows, err := db.Query("SELECT id, content_type, file_name, file_path "+
-		"FROM attachments WHERE email_id = $1", e.ID)
+	rows, err := db.Query("SELECT id, content_type, file_name, file_path, "+
+		"content_id, disposition, is_inline, warning FROM attachments WHERE "+
+		"email_id = $1", e.ID)
 	if err != nil {
 		return err
 	}
@@ -77,7 +92,8 @@ func (e *Email) GetAttachmentsForEmail(db dbInterface) error {
 	for rows.Next() {
 		attachment := Attachment{EmailID: e.ID}
 		err = rows.Scan(&attachment.ID, &attachment.ContentType,
-			&attachment.FileName, &attachment.FilePath)
+			&attachment.FileName, &attachment.FilePath, &attachment.ContentID,
+			&attachment.Disposition, &attachment.IsInline, &attachment.Warning)
 		if err == nil {
 			e.Attachments = append(e.Attachments, attachment)
 		}
@@ -1,8 +1,36 @@
 package models
 
-import "database/sql"
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
 
 type dbInterface interface {
 	Query(query string, args ...interface{}) (*sql.Rows, error)
 	Exec(query string, args ...interface{}) (sql.Result, error)
 }
+
+// nullTime stores unknown (zero) times as NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// IsPermanentError determines if an error from the database was caused by
+// the values being stored, e.g. text which is too long or isn't valid UTF-8,
+// so trying again will fail the same way. Connection and other transient
+// errors aren't permanent
+func IsPermanentError(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	if !ok {
+		return false
+	}
+	switch pqErr.Code.Class() {
+	case "22", "54":
+		// data_exception, e.g. string_data_right_truncation and
+		// character_not_in_repertoire, and program_limit_exceeded
+		return true
+	}
+	return false
+}
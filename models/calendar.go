@@ -0,0 +1,175 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	// CalendarResponseAccepted is a reply accepting a calendar invite
+	CalendarResponseAccepted = "ACCEPTED"
+	// CalendarResponseTentative is a reply tentatively accepting a calendar
+	// invite
+	CalendarResponseTentative = "TENTATIVE"
+	// CalendarResponseDeclined is a reply declining a calendar invite
+	CalendarResponseDeclined = "DECLINED"
+)
+
+// CalendarAttendee is a person invited to a calendar event
+type CalendarAttendee struct {
+	DisplayName  string `json:"display_name"`
+	EmailAddress string `json:"email_address"`
+	Role         string `json:"role"`
+	Status       string `json:"status"`
+	RSVP         bool   `json:"rsvp"`
+}
+
+// String formats an attendee as it would appear in a header
+func (a CalendarAttendee) String() string {
+	return Recipient{a.DisplayName, a.EmailAddress}.String()
+}
+
+// CalendarEvent is an event described by a text/calendar part of an email,
+// such as a meeting invite
+type CalendarEvent struct {
+	ID               int                `json:"id"`
+	EmailID          int                `json:"email_id"`
+	UID              string             `json:"uid"`
+	Method           string             `json:"method"`
+	Sequence         int                `json:"sequence"`
+	Status           string             `json:"status"`
+	Summary          string             `json:"summary"`
+	Description      string             `json:"description"`
+	Location         string             `json:"location"`
+	OrganizerName    string             `json:"organizer_name"`
+	OrganizerAddress string             `json:"organizer_address"`
+	Start            time.Time          `json:"start"`
+	StartTimeZone    string             `json:"start_time_zone"`
+	End              time.Time          `json:"end"`
+	EndTimeZone      string             `json:"end_time_zone"`
+	AllDay           bool               `json:"all_day"`
+	Attendees        []CalendarAttendee `json:"attendees"`
+	Response         string             `json:"response"`
+}
+
+// Organizer gets the organizer of an event as a recipient
+func (c CalendarEvent) Organizer() Recipient {
+	return Recipient{c.OrganizerName, c.OrganizerAddress}
+}
+
+// IsRequest determines if an event is an invite which can be replied to
+func (c CalendarEvent) IsRequest() bool {
+	return c.Method == "REQUEST" && len(c.OrganizerAddress) > 0
+}
+
+// Create attempts to save a calendar event and its attendees
+func (c *CalendarEvent) Create(db dbInterface) error {
+	rows, err := db.Query("INSERT INTO calendar_events (email_id, uid, "+
+		"method, sequence, status, summary, description, location, "+
+		"organizer_name, organizer_address, start_time, start_time_zone, "+
+		"end_time, end_time_zone, all_day) VALUES ($1, $2, $3, $4, $5, $6, $7, "+
+		"$8, $9, $10, $11, $12, $13, $14, $15) RETURNING id", c.EmailID, c.UID,
+		c.Method, c.Sequence, c.Status, c.Summary, c.Description, c.Location,
+		c.OrganizerName, c.OrganizerAddress, c.Start, c.StartTimeZone, c.End,
+		c.EndTimeZone, c.AllDay)
+	if err != nil {
+		return err
+	}
+	if rows.Next() {
+		err = rows.Scan(&c.ID)
+	}
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, attendee := range c.Attendees {
+		_, err = db.Exec("INSERT INTO calendar_attendees (event_id, "+
+			"display_name, email_address, role, status, rsvp) VALUES ($1, $2, "+
+			"$3, $4, $5, $6)", c.ID, attendee.DisplayName, attendee.EmailAddress,
+			attendee.Role, attendee.Status, attendee.RSVP)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetResponse records how the mailbox replied to an event
+func (c *CalendarEvent) SetResponse(db *sql.DB, response string) error {
+	_, err := db.Exec("UPDATE calendar_events SET response = $1 WHERE id = $2",
+		response, c.ID)
+	if err == nil {
+		c.Response = response
+	}
+	return err
+}
+
+// GetCalendarEventsForEmail attempts to load the calendar events of an email
+// into the email object
+func (e *Email) GetCalendarEventsForEmail(db dbInterface) error {
+	rows, err := db.Query("SELECT id, uid, method, sequence, status, summary, "+
+		"description, location, organizer_name, organizer_address, start_time, "+
+		"start_time_zone, end_time, end_time_zone, all_day, response FROM "+
+		"calendar_events WHERE email_id = $1 ORDER BY id", e.ID)
+	if err != nil {
+		return err
+	}
+
+	e.Events = make([]CalendarEvent, 0, 1)
+	for rows.Next() {
+		event := CalendarEvent{EmailID: e.ID}
+		err = rows.Scan(&event.ID, &event.UID, &event.Method, &event.Sequence,
+			&event.Status, &event.Summary, &event.Description, &event.Location,
+			&event.OrganizerName, &event.OrganizerAddress, &event.Start,
+			&event.StartTimeZone, &event.End, &event.EndTimeZone, &event.AllDay,
+			&event.Response)
+		if err == nil {
+			e.Events = append(e.Events, event)
+		}
+	}
+	rows.Close()
+
+	for i := range e.Events {
+		event := &e.Events[i]
+		event.Start = inTimeZone(event.Start, event.StartTimeZone)
+		event.End = inTimeZone(event.End, event.EndTimeZone)
+		if err = event.getAttendees(db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *CalendarEvent) getAttendees(db dbInterface) error {
+	rows, err := db.Query("SELECT display_name, email_address, role, status, "+
+		"rsvp FROM calendar_attendees WHERE event_id = $1", c.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	c.Attendees = make([]CalendarAttendee, 0, 10)
+	for rows.Next() {
+		var attendee CalendarAttendee
+		err = rows.Scan(&attendee.DisplayName, &attendee.EmailAddress,
+			&attendee.Role, &attendee.Status, &attendee.RSVP)
+		if err == nil {
+			c.Attendees = append(c.Attendees, attendee)
+		}
+	}
+	return nil
+}
+
+// inTimeZone converts a time into a named time zone, leaving it as is if the
+// zone is unknown
+func inTimeZone(t time.Time, zone string) time.Time {
+	if len(zone) == 0 {
+		return t
+	}
+	location, err := time.LoadLocation(zone)
+	if err != nil {
+		return t
+	}
+	return t.In(location)
+}
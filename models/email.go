@@ -2,6 +2,7 @@ package models
 
 import (
 	"database/sql"
+	"errors"
 	"strings"
 	"time"
 )
@@ -13,60 +14,167 @@ const (
 	RecipientTypeCC = "cc"
 	// RecipientTypeBCC is any hidden recipient of an email
 	RecipientTypeBCC = "bcc"
+	// RecipientTypeReplyTo is any address replies should be sent to
+	RecipientTypeReplyTo = "reply-to"
+	// RecipientTypeSender is the address which actually sent an email on
+	// behalf of its author
+	RecipientTypeSender = "sender"
+	// RecipientTypeDeliveredTo is any address an email was delivered to, as
+	// recorded by the servers it passed through
+	RecipientTypeDeliveredTo = "delivered-to"
 	// EmailPageSize represents how many emails are loaded per page
 	EmailPageSize = 50
 )
 
-type emailPair struct {
-	DisplayName  string
-	EmailAddress string
+const (
+	// AuthResultPass means a sender passed an authentication check
+	AuthResultPass = "pass"
+	// AuthResultFail means a sender failed an authentication check
+	AuthResultFail = "fail"
+	// AuthResultNone means a sender could not be checked, e.g. because it
+	// publishes no policy
+	AuthResultNone = "none"
+	// AuthResultTempError means a check failed because of a temporary error
+	AuthResultTempError = "temperror"
+	// AuthResultPermError means a check could not be completed, e.g. because
+	// a signature or key is malformed
+	AuthResultPermError = "permerror"
+)
+
+const (
+	// SignatureValid means an S/MIME signature was checked, and the signer is
+	// trusted and matches the sender
+	SignatureValid = "valid"
+	// SignatureUntrusted means an S/MIME signature matches the content, but
+	// the signer is not trusted or is not the sender
+	SignatureUntrusted = "untrusted"
+	// SignatureInvalid means an S/MIME signature does not match the content
+	SignatureInvalid = "invalid"
+	// SignaturePartial means only some parts of an email are signed, so no
+	// signature speaks for the whole email. Each is listed in SMIMEParts
+	SignaturePartial = "partial"
+)
+
+// ErrDuplicateEmail is returned when creating an email which has already been
+// stored in the same mailbox
+var ErrDuplicateEmail = errors.New("Email already exists in this mailbox")
+
+// Recipient is an address named in one of an email's address headers
+type Recipient struct {
+	DisplayName  string `json:"display_name"`
+	EmailAddress string `json:"email_address"`
+}
+
+// String formats a recipient as it would appear in a header
+func (r Recipient) String() string {
+	if len(r.DisplayName) == 0 {
+		return r.EmailAddress
+	}
+	return r.DisplayName + " <" + r.EmailAddress + ">"
 }
 
 // Email encapsulates any information needed to render an
 // email message
 type Email struct {
-	ID             int          `json:"id"`
-	To             []string     `json:"to"`
-	CC             []string     `json:"cc"`
-	BCC            []string     `json:"bcc"`
-	From           string       `json:"from"`
-	FromName       string       `json:"from_name"`
-	Subject        string       `json:"subject"`
-	PlainText      string       `json:"plain_text"`
-	FormattedText  string       `json:"formatted_text"`
-	HasAttachments bool         `json:"has_attachments"`
-	Read           bool         `json:"is_read"`
-	IsSpam         bool         `json:"is_spam"`
-	IsVirus        bool         `json:"is_virus"`
-	Attachments    []Attachment `json:"attachments"`
-	Received       time.Time    `json:"received"`
+	ID                int             `json:"id"`
+	ParentID          int             `json:"parent_id"`
+	Mailbox           string          `json:"mailbox"`
+	MessageID         string          `json:"message_id"`
+	SourceHash        string          `json:"source_hash"`
+	RawPath           string          `json:"raw_path"`
+	RawSource         []byte          `json:"-"`
+	To                []Recipient     `json:"to"`
+	CC                []Recipient     `json:"cc"`
+	BCC               []Recipient     `json:"bcc"`
+	ReplyTo           []Recipient     `json:"reply_to"`
+	Sender            []Recipient     `json:"sender"`
+	DeliveredTo       []Recipient     `json:"delivered_to"`
+	From              string          `json:"from"`
+	FromName          string          `json:"from_name"`
+	Subject           string          `json:"subject"`
+	Charset           string          `json:"charset"`
+	PlainText         string          `json:"plain_text"`
+	FormattedText     string          `json:"formatted_text"`
+	HasAttachments    bool            `json:"has_attachments"`
+	Read              bool            `json:"is_read"`
+	IsSpam            bool            `json:"is_spam"`
+	IsVirus           bool            `json:"is_virus"`
+	SPFVerdict        string          `json:"spf_verdict"`
+	SPFDomain         string          `json:"spf_domain"`
+	DKIMVerdict       string          `json:"dkim_verdict"`
+	DKIMDomain        string          `json:"dkim_domain"`
+	DMARCVerdict      string          `json:"dmarc_verdict"`
+	IsUnauthenticated bool            `json:"is_unauthenticated"`
+	IsEncrypted       bool            `json:"is_encrypted"`
+	SignatureStatus   string          `json:"signature_status"`
+	SignatureError    string          `json:"signature_error"`
+	Signer            string          `json:"signer"`
+	SignerIssuer      string          `json:"signer_issuer"`
+	SignerExpires     time.Time       `json:"signer_expires"`
+	SMIMEParts        []string        `json:"smime_parts"`
+	LoadRemoteContent bool            `json:"load_remote_content"`
+	Warnings          []string        `json:"warnings"`
+	Attachments       []Attachment    `json:"attachments"`
+	Children          []Email         `json:"children"`
+	Events            []CalendarEvent `json:"events"`
+	Headers           []Header        `json:"headers"`
+	Sent              time.Time       `json:"sent"`
+	Received          time.Time       `json:"received"`
 }
 
-// Create attempts to add an email to the database
+// Create attempts to add an email to the database, along with any emails
+// attached to it. Returns ErrDuplicateEmail if the same source, or another
+// email with the same Message-ID, has already been stored in the mailbox
 func (e *Email) Create(db *sql.DB) error {
 	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
-	fromPair := parseEmailAddress(e.From)
-	rows, err := tx.Query("INSERT INTO emails (from_display, from_addr, "+
-		"subject, plain_text, formatted_text, is_spam, is_virus, received) VALUES "+
-		"($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id", fromPair.DisplayName,
-		fromPair.EmailAddress, e.Subject, e.PlainText, e.FormattedText, e.IsSpam,
-		e.IsVirus, e.Received)
+
+	err = e.create(tx)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
+
+	err = tx.Commit()
+	return err
+}
+
+func (e *Email) create(tx *sql.Tx) error {
+	// Only top level emails are unique within a mailbox, by source and by
+	// Message-ID, since the same message can be attached to any number of
+	// others. A conflict on either means it's already stored, even if it was
+	// stored by another delivery at the same time
+	rows, err := tx.Query("INSERT INTO emails (parent_id, mailbox, "+
+		"message_id, source_hash, from_display, from_addr, subject, charset, "+
+		"plain_text, formatted_text, is_spam, is_virus, spf_verdict, "+
+		"spf_domain, dkim_verdict, dkim_domain, dmarc_verdict, "+
+		"is_unauthenticated, is_encrypted, signature_status, signature_error, "+
+		"signer, signer_issuer, signer_expires, smime_parts, warnings, sent, "+
+		"received) VALUES (NULLIF($1, 0), $2, $3, $4, $5, $6, $7, $8, $9, $10, "+
+		"$11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, "+
+		"$25, $26, $27, $28) ON CONFLICT DO NOTHING RETURNING id", e.ParentID, e.Mailbox, e.MessageID,
+		e.SourceHash, e.FromName, e.From, e.Subject, e.Charset, e.PlainText,
+		e.FormattedText, e.IsSpam, e.IsVirus, e.SPFVerdict, e.SPFDomain,
+		e.DKIMVerdict, e.DKIMDomain, e.DMARCVerdict, e.IsUnauthenticated,
+		e.IsEncrypted, e.SignatureStatus, e.SignatureError, e.Signer,
+		e.SignerIssuer, nullTime(e.SignerExpires),
+		strings.Join(e.SMIMEParts, "\n"), strings.Join(e.Warnings, "\n"),
+		nullTime(e.Sent), e.Received)
+	if err != nil {
+		return err
+	}
 	if rows.Next() {
 		err = rows.Scan(&e.ID)
 		rows.Close()
 		if err != nil {
-			tx.Rollback()
 			return err
 		}
 	} else {
+		// Nothing was inserted, so the mailbox already has this email
 		rows.Close()
+		return ErrDuplicateEmail
 	}
 
 	for i := 0; i < len(e.Attachments); i++ {
@@ -74,34 +182,61 @@ func (e *Email) Create(db *sql.DB) error {
 		a.EmailID = e.ID
 		err = a.Create(tx)
 		if err != nil {
-			tx.Rollback()
 			return err
 		}
 	}
 
 	if err = e.addRecipients(tx, e.To, RecipientTypeTo); err != nil {
-		tx.Rollback()
 		return err
 	}
 	if err = e.addRecipients(tx, e.CC, RecipientTypeCC); err != nil {
-		tx.Rollback()
 		return err
 	}
 	if err = e.addRecipients(tx, e.BCC, RecipientTypeBCC); err != nil {
-		tx.Rollback()
+		return err
+	}
+	if err = e.addRecipients(tx, e.ReplyTo, RecipientTypeReplyTo); err != nil {
+		return err
+	}
+	if err = e.addRecipients(tx, e.Sender, RecipientTypeSender); err != nil {
+		return err
+	}
+	err = e.addRecipients(tx, e.DeliveredTo, RecipientTypeDeliveredTo)
+	if err != nil {
 		return err
 	}
 
-	err = tx.Commit()
-	return err
+	if err = e.createHeaders(tx); err != nil {
+		return err
+	}
+
+	for i := 0; i < len(e.Events); i++ {
+		event := &e.Events[i]
+		event.EmailID = e.ID
+		if err = event.Create(tx); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < len(e.Children); i++ {
+		child := &e.Children[i]
+		child.ParentID = e.ID
+		child.Mailbox = e.Mailbox
+		child.Received = e.Received
+		if err = child.create(tx); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (e *Email) addRecipients(tx *sql.Tx, emailAddresses []string,
+func (e *Email) addRecipients(tx *sql.Tx, recipients []Recipient,
 	recipientType string) error {
 
-	for _, emailAddress := range emailAddresses {
-		if len(emailAddress) > 3 {
-			if err := e.addRecipient(tx, emailAddress, RecipientTypeTo); err != nil {
+	for _, recipient := range recipients {
+		if len(recipient.EmailAddress) > 3 {
+			err := e.addRecipient(tx, recipient, recipientType)
+			if err != nil {
 				return err
 			}
 		}
@@ -109,12 +244,12 @@ func (e *Email) addRecipients(tx *sql.Tx, emailAddresses []string,
 	return nil
 }
 
-func (e *Email) addRecipient(tx *sql.Tx, emailAddress string,
+func (e *Email) addRecipient(tx *sql.Tx, recipient Recipient,
 	recipientType string) error {
 
-	_, err := tx.Exec("INSERT INTO recipients (email_id, email_address, "+
-		"recipient_type) VALUES ($1, $2, $3)", e.ID, emailAddress,
-		recipientType)
+	_, err := tx.Exec("INSERT INTO recipients (email_id, display_name, "+
+		"email_address, recipient_type) VALUES ($1, $2, $3, $4)", e.ID,
+		recipient.DisplayName, recipient.EmailAddress, recipientType)
 	return err
 }
 
@@ -155,11 +290,36 @@ func (e *Email) Delete(db *sql.DB) error {
 	return err
 }
 
-// GetEmailByID attempts to load an email into memory and return it
+// EmailExists determines if a mailbox already holds an email, either with
+// the same Message-ID header or the same raw source. This is only a shortcut,
+// as Create turns away duplicates which are being stored at the same time
+func EmailExists(db *sql.DB, mailbox string, messageID string,
+	sourceHash string) (bool, error) {
+
+	row := db.QueryRow("SELECT count(1) FROM emails WHERE mailbox = $1 AND "+
+		"parent_id IS NULL AND (source_hash = $2 OR (message_id <> '' AND "+
+		"message_id = $3))", mailbox, sourceHash, messageID)
+
+	var count int
+	err := row.Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// GetEmailByID attempts to load an email into memory and return it, along
+// with any emails attached to it
 func GetEmailByID(db *sql.DB, id int) (*Email, error) {
 	// Build the base email struct
-	rows, err := db.Query("SELECT from_display, from_addr, subject, plain_text, "+
-		"formatted_text, is_spam, is_virus, received FROM emails WHERE id = $1", id)
+	rows, err := db.Query("SELECT COALESCE(parent_id, 0), mailbox, "+
+		"message_id, source_hash, raw_path, from_display, from_addr, subject, "+
+		"charset, plain_text, formatted_text, is_spam, is_virus, spf_verdict, "+
+		"spf_domain, dkim_verdict, dkim_domain, dmarc_verdict, "+
+		"is_unauthenticated, is_encrypted, signature_status, signature_error, "+
+		"signer, signer_issuer, signer_expires, smime_parts, "+
+		"load_remote_content, warnings, sent, received FROM emails "+
+		"WHERE id = $1", id)
 	if err != nil {
 		return nil, err
 	}
@@ -169,38 +329,62 @@ func GetEmailByID(db *sql.DB, id int) (*Email, error) {
 	}
 
 	email := Email{ID: id}
-	err = rows.Scan(&email.FromName, &email.From, &email.Subject,
-		&email.PlainText, &email.FormattedText, &email.IsSpam, &email.IsVirus,
-		&email.Received)
+	var sent, signerExpires sql.NullTime
+	var smimeParts, warnings string
+	err = rows.Scan(&email.ParentID, &email.Mailbox, &email.MessageID,
+		&email.SourceHash, &email.RawPath, &email.FromName, &email.From,
+		&email.Subject, &email.Charset, &email.PlainText, &email.FormattedText,
+		&email.IsSpam, &email.IsVirus, &email.SPFVerdict, &email.SPFDomain,
+		&email.DKIMVerdict, &email.DKIMDomain, &email.DMARCVerdict,
+		&email.IsUnauthenticated, &email.IsEncrypted, &email.SignatureStatus,
+		&email.SignatureError, &email.Signer, &email.SignerIssuer,
+		&signerExpires, &smimeParts, &email.LoadRemoteContent, &warnings,
+		&sent, &email.Received)
 	if err != nil {
 		return nil, err
 	}
+	email.Sent = sent.Time
+	email.SignerExpires = signerExpires.Time
+	if len(smimeParts) > 0 {
+		email.SMIMEParts = strings.Split(smimeParts, "\n")
+	}
+	if len(warnings) > 0 {
+		email.Warnings = strings.Split(warnings, "\n")
+	}
 
 	// Load in recipients
-	email.To = make([]string, 0, 10)
-	email.CC = make([]string, 0, 10)
-	email.BCC = make([]string, 0, 10)
+	email.To = make([]Recipient, 0, 10)
+	email.CC = make([]Recipient, 0, 10)
+	email.BCC = make([]Recipient, 0, 10)
 
-	rows2, err := db.Query("SELECT email_address, recipient_type FROM "+
-		"recipients WHERE email_id = $1", id)
+	rows2, err := db.Query("SELECT display_name, email_address, "+
+		"recipient_type FROM recipients WHERE email_id = $1", id)
 	if err != nil {
 		return nil, err
 	}
 	defer rows2.Close()
 
 	for rows2.Next() {
-		var emailAddress, recipientType string
-		err = rows2.Scan(&emailAddress, &recipientType)
+		var recipient Recipient
+		var recipientType string
+		err = rows2.Scan(&recipient.DisplayName, &recipient.EmailAddress,
+			&recipientType)
 		if err != nil {
 			return nil, err
 		}
 		switch recipientType {
-		case "to":
-			email.To = append(email.To, emailAddress)
-		case "cc":
-			email.CC = append(email.CC, emailAddress)
-		case "bcc":
-			email.BCC = append(email.BCC, emailAddress)
+		case RecipientTypeTo:
+			email.To = append(email.To, recipient)
+		case RecipientTypeCC:
+			email.CC = append(email.CC, recipient)
+		case RecipientTypeBCC:
+			email.BCC = append(email.BCC, recipient)
+		case RecipientTypeReplyTo:
+			email.ReplyTo = append(email.ReplyTo, recipient)
+		case RecipientTypeSender:
+			email.Sender = append(email.Sender, recipient)
+		case RecipientTypeDeliveredTo:
+			email.DeliveredTo = append(email.DeliveredTo, recipient)
 		}
 	}
 
@@ -210,20 +394,85 @@ func GetEmailByID(db *sql.DB, id int) (*Email, error) {
 		return nil, err
 	}
 
+	// Load in every header
+	err = email.GetHeadersForEmail(db)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load in calendar events
+	err = email.GetCalendarEventsForEmail(db)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load in attached emails
+	childIDs, err := getChildEmailIDs(db, id)
+	if err != nil {
+		return nil, err
+	}
+	email.Children = make([]Email, 0, len(childIDs))
+	for _, childID := range childIDs {
+		child, err := GetEmailByID(db, childID)
+		if err != nil {
+			return nil, err
+		}
+		if child != nil {
+			email.Children = append(email.Children, *child)
+		}
+	}
+
 	return &email, nil
 }
 
-// IsRecipient determines if a user received an email
-func (u *User) IsRecipient(db *sql.DB, emailID int) (bool, error) {
-	row := db.QueryRow("SELECT count(1) FROM recipients WHERE email_id = "+
-		"$1 AND email_address = $2", emailID, u.EmailAddress)
-
-	var count int
-	err := row.Scan(&count)
+func getChildEmailIDs(db *sql.DB, parentID int) ([]int, error) {
+	rows, err := db.Query("SELECT id FROM emails WHERE parent_id = $1 "+
+		"ORDER BY id", parentID)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	return count > 0, nil
+	defer rows.Close()
+
+	ids := make([]int, 0, 2)
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// SetRawPath records where the original source of an email is stored
+func (e *Email) SetRawPath(db *sql.DB, rawPath string) error {
+	_, err := db.Exec("UPDATE emails SET raw_path = $1 WHERE id = $2", rawPath,
+		e.ID)
+	if err == nil {
+		e.RawPath = rawPath
+	}
+	return err
+}
+
+// SetMailbox moves an email into a mailbox
+func (e *Email) SetMailbox(db *sql.DB, mailbox string) error {
+	_, err := db.Exec("UPDATE emails SET mailbox = $1 WHERE id = $2", mailbox,
+		e.ID)
+	if err == nil {
+		e.Mailbox = mailbox
+	}
+	return err
+}
+
+// SetLoadRemoteContent decides if remote content, such as images, is loaded
+// when the email is shown
+func (e *Email) SetLoadRemoteContent(db *sql.DB, load bool) error {
+	_, err := db.Exec("UPDATE emails SET load_remote_content = $1 WHERE id = $2",
+		load, e.ID)
+	if err == nil {
+		e.LoadRemoteContent = load
+	}
+	return err
 }
 
 // MarkRead attempts to mark an email read
@@ -268,51 +517,82 @@ func (u *User) GetEmailCount(db *sql.DB) int {
 	return 0
 }
 
-// LoadEmailPage gets a page of emails for a user
-func (u *User) LoadEmailPage(db *sql.DB, page int) ([]Email, error) {
-	emails, err := LoadEmailsForAddress(db, u.EmailAddress, page)
-	return emails, err
-}
-
-// LoadEmailsForAddress attempts to get stubs for emails sent to a user
-func LoadEmailsForAddress(db *sql.DB, emailAddress string, page int) ([]Email,
+// emailStubQuery selects the fields of emails shown in a list. It is
+// followed by the conditions on which emails are listed
+const emailStubQuery = "SELECT id, mailbox, from_display, from_addr, " +
+	"subject, plain_text, formatted_text, is_read, is_spam, is_virus, " +
+	"is_unauthenticated, sent, received, (exists(SELECT * FROM attachments WHERE email_id = e.id " +
+	"AND NOT is_inline) OR exists(SELECT * FROM emails c WHERE " +
+	"c.parent_id = e.id)) has_attachments FROM emails e WHERE "
+
+// LoadEmailsForMailbox attempts to get stubs for a page of emails delivered
+// to a mailbox
+func LoadEmailsForMailbox(db *sql.DB, mailbox string, page int) ([]Email,
 	error) {
 
-	rows, err := db.Query("SELECT id, from_display, from_addr, subject, "+
-		"plain_text, formatted_text, is_read, is_spam, is_virus, received, "+
-		"(exists(SELECT * FROM attachments WHERE email_id = e.id)) has_attachments "+
-		"FROM emails e WHERE exists(SELECT * FROM recipients WHERE "+
-		"email_id = e.id AND email_address = $1) ORDER BY received DESC LIMIT $2 "+
-		"OFFSET $3", emailAddress, EmailPageSize, EmailPageSize*page)
+	rows, err := db.Query(emailStubQuery+"mailbox = $1 AND parent_id IS NULL "+
+		"ORDER BY received DESC LIMIT $2 OFFSET $3", mailbox, EmailPageSize,
+		EmailPageSize*page)
+	if err != nil {
+		return nil, err
+	}
+	return scanEmailStubs(rows), nil
+}
+
+// LoadEmailsWithHeader attempts to get stubs for a page of emails delivered
+// to a mailbox which have a header containing the given value. Header names
+// and values are matched without regard to case, and an empty value matches
+// any email with the header
+func LoadEmailsWithHeader(db *sql.DB, mailbox string, name string,
+	value string, page int) ([]Email, error) {
+
+	rows, err := db.Query(emailStubQuery+"mailbox = $1 AND parent_id IS NULL "+
+		"AND exists(SELECT * FROM email_headers h WHERE h.email_id = e.id AND "+
+		"lower(h.name) = lower($2) AND strpos(lower(h.value), lower($3)) > 0) "+
+		"ORDER BY received DESC LIMIT $4 OFFSET $5", mailbox, name, value,
+		EmailPageSize, EmailPageSize*page)
 	if err != nil {
 		return nil, err
 	}
+	return scanEmailStubs(rows), nil
+}
+
+func scanEmailStubs(rows *sql.Rows) []Email {
 	defer rows.Close()
 
 	emails := make([]Email, 0, 50)
 	for rows.Next() {
 		var email Email
-		err = rows.Scan(&email.ID, &email.FromName, &email.From, &email.Subject,
-			&email.PlainText, &email.FormattedText, &email.Read, &email.IsSpam,
-			&email.IsVirus, &email.Received, &email.HasAttachments)
+		var sent sql.NullTime
+		err := rows.Scan(&email.ID, &email.Mailbox, &email.FromName, &email.From,
+			&email.Subject, &email.PlainText, &email.FormattedText, &email.Read,
+			&email.IsSpam, &email.IsVirus, &email.IsUnauthenticated, &sent,
+			&email.Received, &email.HasAttachments)
+		email.Sent = sent.Time
 		if err == nil {
 			emails = append(emails, email)
 		}
 	}
 
-	return emails, nil
+	return emails
 }
 
-func parseEmailAddress(emailString string) emailPair {
-	idxStart := strings.Index(emailString, "<")
-	idxEnd := strings.Index(emailString, ">")
-	pair := emailPair{}
-	if idxEnd > idxStart {
-		pair.DisplayName = strings.TrimSpace(emailString[0:idxStart])
-		pair.EmailAddress = strings.TrimSpace(emailString[idxStart+1 : idxEnd])
-	} else {
-		pair.DisplayName = emailString
-		pair.EmailAddress = emailString
+// GetUnassignedEmailIDs finds emails stored before mail was delivered into
+// mailboxes, which have yet to be assigned one
+func GetUnassignedEmailIDs(db *sql.DB) ([]int, error) {
+	rows, err := db.Query("SELECT id FROM emails WHERE parent_id IS NULL " +
+		"AND (mailbox = '' OR mailbox IS NULL)")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int, 0, 10)
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
 	}
-	return pair
+	return ids, nil
 }
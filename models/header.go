@@ -0,0 +1,39 @@
+package models
+
+// Header is a single header line of an email
+type Header struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// createHeaders saves the headers of an email, keeping their order
+func (e *Email) createHeaders(db dbInterface) error {
+	for i, header := range e.Headers {
+		_, err := db.Exec("INSERT INTO email_headers (email_id, position, name, "+
+			"value) VALUES ($1, $2, $3, $4)", e.ID, i, header.Name, header.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetHeadersForEmail attempts to load the headers of an email, in order, into
+// the email object
+func (e *Email) GetHeadersForEmail(db dbInterface) error {
+	rows, err := db.Query("SELECT name, value FROM email_headers WHERE "+
+		"email_id = $1 ORDER BY position", e.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	e.Headers = make([]Header, 0, 32)
+	for rows.Next() {
+		var header Header
+		if err = rows.Scan(&header.Name, &header.Value); err == nil {
+			e.Headers = append(e.Headers, header)
+		}
+	}
+	return nil
+}
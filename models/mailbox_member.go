@@ -0,0 +1,47 @@
+package models
+
+import "database/sql"
+
+// AllUsers is the username which shares a mailbox with every user
+const AllUsers = "*"
+
+// MailboxMember lets a user read a shared mailbox
+type MailboxMember struct {
+	Mailbox  string `json:"mailbox"`
+	Username string `json:"username"`
+}
+
+// Create attempts to add a member to the database
+func (m *MailboxMember) Create(db *sql.DB) error {
+	_, err := db.Exec("INSERT INTO mailbox_members (mailbox, username) "+
+		"VALUES ($1, $2) ON CONFLICT DO NOTHING", m.Mailbox, m.Username)
+	return err
+}
+
+// Delete attempts to remove a member from the database
+func (m *MailboxMember) Delete(db *sql.DB) error {
+	_, err := db.Exec("DELETE FROM mailbox_members WHERE mailbox = $1 AND "+
+		"username = $2", m.Mailbox, m.Username)
+	return err
+}
+
+// GetMemberMailboxes lists the shared mailboxes a user is a member of,
+// including those shared with every user
+func GetMemberMailboxes(db *sql.DB, username string) ([]string, error) {
+	rows, err := db.Query("SELECT DISTINCT mailbox FROM mailbox_members "+
+		"WHERE username = $1 OR username = $2 ORDER BY mailbox", username,
+		AllUsers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mailboxes := make([]string, 0, 4)
+	for rows.Next() {
+		var mailbox string
+		if err = rows.Scan(&mailbox); err == nil {
+			mailboxes = append(mailboxes, mailbox)
+		}
+	}
+	return mailboxes, nil
+}
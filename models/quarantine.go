@@ -0,0 +1,114 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// QuarantinedMessage encapsulates an inbound message which could not be
+// parsed. The raw message is kept in attachment storage at FilePath
+type QuarantinedMessage struct {
+	ID         int       `json:"id"`
+	Mailbox    string    `json:"mailbox"`
+	SourceKey  string    `json:"source_key"`
+	SourceHash string    `json:"source_hash"`
+	Reason     string    `json:"reason"`
+	FilePath   string    `json:"file_path"`
+	Created    time.Time `json:"created"`
+}
+
+// Create attempts to save information about a quarantined message
+func (q *QuarantinedMessage) Create(db *sql.DB) error {
+	rows, err := db.Query("INSERT INTO quarantine (mailbox, source_key, "+
+		"source_hash, reason, file_path, created) VALUES ($1, $2, $3, $4, $5, "+
+		"$6) RETURNING id", q.Mailbox, q.SourceKey, q.SourceHash, q.Reason,
+		q.FilePath, q.Created)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		rows.Scan(&q.ID)
+	}
+	return nil
+}
+
+// Save attempts to update information about a quarantined message
+func (q *QuarantinedMessage) Save(db *sql.DB) error {
+	_, err := db.Exec("UPDATE quarantine SET mailbox = $1, source_key = $2, "+
+		"reason = $3, file_path = $4 WHERE id = $5", q.Mailbox, q.SourceKey,
+		q.Reason, q.FilePath, q.ID)
+	return err
+}
+
+// Delete attempts to remove a quarantined message from the database
+func (q *QuarantinedMessage) Delete(db *sql.DB) error {
+	_, err := db.Exec("DELETE FROM quarantine WHERE id = $1", q.ID)
+	return err
+}
+
+// QuarantineExists checks if a mailbox has already quarantined a message
+func QuarantineExists(db *sql.DB, mailbox string,
+	sourceHash string) (bool, error) {
+
+	row := db.QueryRow("SELECT count(1) FROM quarantine WHERE mailbox = $1 "+
+		"AND source_hash = $2", mailbox, sourceHash)
+
+	var count int
+	err := row.Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// GetQuarantinedMessageByID tries to find a quarantined message by its ID
+func GetQuarantinedMessageByID(db *sql.DB, id int) (*QuarantinedMessage,
+	error) {
+
+	rows, err := db.Query("SELECT id, mailbox, source_key, source_hash, "+
+		"reason, file_path, created FROM quarantine WHERE id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var q QuarantinedMessage
+		err = rows.Scan(&q.ID, &q.Mailbox, &q.SourceKey, &q.SourceHash,
+			&q.Reason, &q.FilePath, &q.Created)
+		if err == nil {
+			return &q, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// LoadQuarantinedMessages gets every quarantined message for the given
+// mailboxes, newest first
+func LoadQuarantinedMessages(db *sql.DB,
+	mailboxes []string) ([]QuarantinedMessage, error) {
+
+	messages := make([]QuarantinedMessage, 0, 10)
+	for _, mailbox := range mailboxes {
+		rows, err := db.Query("SELECT id, mailbox, source_key, source_hash, "+
+			"reason, file_path, created FROM quarantine WHERE mailbox = $1 "+
+			"ORDER BY created DESC", mailbox)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var q QuarantinedMessage
+			err = rows.Scan(&q.ID, &q.Mailbox, &q.SourceKey, &q.SourceHash,
+				&q.Reason, &q.FilePath, &q.Created)
+			if err == nil {
+				messages = append(messages, q)
+			}
+		}
+		rows.Close()
+	}
+
+	return messages, nil
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// AllowRemoteContent records that remote content, such as images, should be
+// loaded in mail to a mailbox from a sender
+func AllowRemoteContent(db *sql.DB, mailbox string, address string) error {
+	_, err := db.Exec("INSERT INTO remote_content_senders (mailbox, address) "+
+		"VALUES ($1, $2) ON CONFLICT DO NOTHING", mailbox,
+		strings.ToLower(address))
+	return err
+}
+
+// IsRemoteContentAllowed determines if remote content should be loaded in
+// mail to a mailbox from a sender
+func IsRemoteContentAllowed(db *sql.DB, mailbox string,
+	address string) (bool, error) {
+
+	rows, err := db.Query("SELECT 1 FROM remote_content_senders WHERE "+
+		"mailbox = $1 AND address = $2", mailbox, strings.ToLower(address))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	return rows.Next(), nil
+}
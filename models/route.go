@@ -0,0 +1,134 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// MailRoute maps an address onto the mailbox its mail is delivered to.
+// Address is either a full address, which may be an alias for a user, or
+// "*@domain" to catch all otherwise unrouted mail for a domain
+type MailRoute struct {
+	ID      int    `json:"id"`
+	Address string `json:"address"`
+	Mailbox string `json:"mailbox"`
+}
+
+// Create attempts to add a route to the database
+func (m *MailRoute) Create(db *sql.DB) error {
+	rows, err := db.Query("INSERT INTO mail_routes (address, mailbox) VALUES "+
+		"($1, $2) RETURNING id", strings.ToLower(m.Address), m.Mailbox)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		rows.Scan(&m.ID)
+	}
+	return nil
+}
+
+// Delete attempts to remove a route from the database
+func (m *MailRoute) Delete(db *sql.DB) error {
+	_, err := db.Exec("DELETE FROM mail_routes WHERE id = $1", m.ID)
+	return err
+}
+
+// GetMailRouteByAddress tries to find the route for an exact address
+func GetMailRouteByAddress(db *sql.DB, address string) (*MailRoute, error) {
+	rows, err := db.Query("SELECT id, address, mailbox FROM mail_routes "+
+		"WHERE address = $1", strings.ToLower(address))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var route MailRoute
+		err = rows.Scan(&route.ID, &route.Address, &route.Mailbox)
+		if err == nil {
+			return &route, nil
+		}
+	}
+	return nil, nil
+}
+
+// ResolveMailbox finds the mailbox mail for an address is delivered to.
+// Addresses are matched in order: an exact route or the address of a user,
+// the same again with any +tag removed, then the catch-all for the domain.
+// Returns an empty string if the address isn't routed anywhere
+func ResolveMailbox(db *sql.DB, address string) (string, error) {
+	address = strings.ToLower(strings.TrimSpace(address))
+	idx := strings.LastIndex(address, "@")
+	if idx < 1 {
+		return "", nil
+	}
+	local, domain := address[:idx], address[idx+1:]
+
+	candidates := []string{address}
+	if plus := strings.Index(local, "+"); plus > 0 {
+		candidates = append(candidates, local[:plus]+"@"+domain)
+	}
+
+	for _, candidate := range candidates {
+		route, err := GetMailRouteByAddress(db, candidate)
+		if err != nil {
+			return "", err
+		}
+		if route != nil {
+			return route.Mailbox, nil
+		}
+
+		// Every user's own address is routed to their mailbox
+		user, err := GetUserByEmailAddress(db, candidate)
+		if err != nil {
+			return "", err
+		}
+		if user != nil {
+			return user.Username, nil
+		}
+	}
+
+	route, err := GetMailRouteByAddress(db, "*@"+domain)
+	if err != nil || route == nil {
+		return "", err
+	}
+	return route.Mailbox, nil
+}
+
+// GetRoutedMailboxes lists every mailbox the routing table delivers to
+func GetRoutedMailboxes(db *sql.DB) ([]string, error) {
+	rows, err := db.Query("SELECT DISTINCT mailbox FROM mail_routes " +
+		"ORDER BY mailbox")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mailboxes := make([]string, 0, 10)
+	for rows.Next() {
+		var mailbox string
+		if err = rows.Scan(&mailbox); err == nil {
+			mailboxes = append(mailboxes, mailbox)
+		}
+	}
+	return mailboxes, nil
+}
+
+// GetMailboxAddress finds the address of a mailbox, which is the first
+// address routed to it other than a catch-all. Returns an empty string if
+// no such address is routed to it
+func GetMailboxAddress(db *sql.DB, mailbox string) (string, error) {
+	rows, err := db.Query("SELECT address FROM mail_routes WHERE mailbox = $1 "+
+		"AND address NOT LIKE '*@%' ORDER BY id LIMIT 1", mailbox)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var address string
+	if rows.Next() {
+		err = rows.Scan(&address)
+	}
+	return address, err
+}
@@ -111,6 +111,32 @@ func GetUserByUsername(db *sql.DB, username string) (*User, error) {
 	return user, err
 }
 
+// GetUserByEmailAddress tries to get data about a user from the database by
+// their email address
+func GetUserByEmailAddress(db *sql.DB, emailAddress string) (*User, error) {
+	user, err := userGetter(db, "lower(email_address) = lower($1)",
+		[]interface{}{emailAddress})
+	return user, err
+}
+
+// GetUsernames gets the username of every user
+func GetUsernames(db *sql.DB) ([]string, error) {
+	rows, err := db.Query("SELECT username FROM admusers ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usernames := make([]string, 0, 10)
+	for rows.Next() {
+		var username string
+		if err = rows.Scan(&username); err == nil {
+			usernames = append(usernames, username)
+		}
+	}
+	return usernames, nil
+}
+
 func userGetter(db *sql.DB, where string, args []interface{}) (*User, error) {
 	rows, err := db.Query("SELECT id, username, email_address, password, salt "+
 		"FROM admusers WHERE "+where, args...)
@@ -1,15 +1,23 @@
 package services
 
-import "database/sql"
+import (
+	"database/sql"
+
+	"github.com/anishmgoyal/calagora-admin/sources"
+)
 
 // Base contains information needed by all or most or many services
 var Base struct {
 	// DB is the handle to the local database
 	DB *sql.DB
+	// Source is where inbound mail is collected from
+	Source sources.MailSource
 }
 
 // BaseInitialization sets up services
-func BaseInitialization(db *sql.DB) {
+func BaseInitialization(db *sql.DB, source sources.MailSource) {
 	Base.DB = db
+	Base.Source = source
+	initIngest()
 	initSessions()
 }
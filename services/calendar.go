@@ -0,0 +1,81 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/anishmgoyal/calagora-admin/models"
+	"github.com/anishmgoyal/calagora-admin/utils"
+)
+
+var calendarResponseVerbs = map[string]string{
+	models.CalendarResponseAccepted:  "Accepted",
+	models.CalendarResponseTentative: "Tentatively Accepted",
+	models.CalendarResponseDeclined:  "Declined",
+}
+
+// RespondToEvent replies to a calendar invite on behalf of the mailbox the
+// invite was delivered to. response is one of the models.CalendarResponse
+// values
+func RespondToEvent(email *models.Email, event *models.CalendarEvent,
+	response string) error {
+
+	verb, ok := calendarResponseVerbs[response]
+	if !ok {
+		return errors.New("Unknown calendar response")
+	}
+	if !event.IsRequest() {
+		return errors.New("This event can't be replied to")
+	}
+
+	attendee := eventAttendee(email.Mailbox, event)
+	reply := utils.BuildCalendarReply(event, attendee, response, time.Now())
+
+	name := attendee.DisplayName
+	if len(name) == 0 {
+		name = attendee.EmailAddress
+	}
+	text := name + " has " + strings.ToLower(verb) + " your invitation"
+	if len(event.Summary) > 0 {
+		text += " to " + event.Summary
+	}
+
+	from := models.Recipient{
+		DisplayName:  attendee.DisplayName,
+		EmailAddress: attendee.EmailAddress,
+	}
+	raw := utils.ComposeEmail(from, []models.Recipient{event.Organizer()},
+		verb+": "+event.Summary, email.MessageID, []utils.OutboundPart{
+			{ContentType: "text/plain; charset=utf-8", Body: []byte(text + ".")},
+			{ContentType: "text/calendar; charset=utf-8; method=REPLY",
+				Body: reply},
+		})
+
+	err := SendEmail(attendee.EmailAddress,
+		[]string{event.OrganizerAddress}, raw)
+	if err != nil {
+		return err
+	}
+	return event.SetResponse(Base.DB, response)
+}
+
+// eventAttendee finds the attendee of an event which a mailbox receives mail
+// for, falling back to the mailbox's own address
+func eventAttendee(mailbox string,
+	event *models.CalendarEvent) models.CalendarAttendee {
+
+	address := mailboxAddress(mailbox)
+	for _, attendee := range event.Attendees {
+		if strings.EqualFold(attendee.EmailAddress, address) {
+			return attendee
+		}
+	}
+	for _, attendee := range event.Attendees {
+		routed, ok := mailboxForAddress(attendee.EmailAddress)
+		if ok && strings.Compare(routed, mailbox) == 0 {
+			return attendee
+		}
+	}
+	return models.CalendarAttendee{EmailAddress: address}
+}
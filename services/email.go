@@ -1,76 +1,218 @@
 package services
 
 import (
-	"bytes"
+	"context"
 	"fmt"
-	"io/ioutil"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/anishmgoyal/calagora-admin/constants"
 	"github.com/anishmgoyal/calagora-admin/models"
 	"github.com/anishmgoyal/calagora-admin/utils"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
 )
 
-// DownloadEmailForUser attempts to download a user's emails from the
-// S3 bucket containing the emails
-func DownloadEmailForUser(username string, event chan string) {
-	markDownloadFinished := func() { event <- username }
-	defer markDownloadFinished()
+// ingestSlots caps the number of messages being ingested across all
+// mailboxes. A slot is taken by sending to the channel
+var ingestSlots chan struct{}
 
-	svc := s3.New(session.New(), &aws.Config{
-		Region: aws.String(constants.S3RegionString),
-	})
+// dkimResolver looks up the keys for checking DKIM signatures locally
+var dkimResolver utils.DNSResolver = utils.NetResolver{}
 
-	response, err := svc.ListObjects(&s3.ListObjectsInput{
-		Bucket: aws.String(constants.S3Bucket),
-		Prefix: aws.String(username),
-	})
+func initIngest() {
+	workers := constants.IngestWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	ingestSlots = make(chan struct{}, workers)
+	dkimResolver = utils.NetResolver{
+		Timeout: time.Duration(constants.DKIMLookupTimeout) * time.Second,
+	}
+}
 
+// DownloadEmail attempts to download a mailbox's emails from the configured
+// mail source. Messages are ingested in parallel, limited both per mailbox
+// and across all mailboxes. Stops early if ctx is cancelled
+func DownloadEmail(ctx context.Context, mailbox string) {
+	keys, err := Base.Source.List(mailbox)
 	if err != nil {
+		fmt.Println("[INGEST] Failed to list " + mailbox + ": " + err.Error())
 		return
 	}
 
-	for _, objectInfo := range response.Contents {
-		object, err := svc.GetObject(&s3.GetObjectInput{
-			Bucket: aws.String(constants.S3Bucket),
-			Key:    objectInfo.Key,
-		})
-		if err != nil {
-			continue
+	workers := constants.IngestMailboxWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range queue {
+				select {
+				case ingestSlots <- struct{}{}:
+				case <-ctx.Done():
+					continue
+				}
+				downloadEmail(mailbox, key)
+				<-ingestSlots
+			}
+		}()
+	}
+
+	for _, key := range keys {
+		select {
+		case queue <- key:
+		case <-ctx.Done():
 		}
-		body, err := ioutil.ReadAll(object.Body)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(queue)
+	wg.Wait()
+}
+
+func downloadEmail(mailbox string, key string) {
+	body, received, err := Base.Source.Fetch(key)
+	if err != nil {
+		return
+	}
+	recipients, mailboxes := routeMessage(body, mailbox)
+	for _, err := range deliverRaw(body, key, received, recipients,
+		mailboxes) {
 		if err != nil {
-			continue
+			return
+		}
+	}
+	// We successfully downloaded the email... remove it from the source
+	Base.Source.Acknowledge(key)
+}
+
+// deliverRaw stores one copy of a raw message in each distinct mailbox,
+// returning the result of each delivery keyed by mailbox. recipients[i] is
+// the envelope recipient routed to mailboxes[i]. Each copy only records the
+// recipients routed to its own mailbox, so one mailbox can't see who else
+// was sent the message
+func deliverRaw(raw []byte, sourceKey string, received time.Time,
+	recipients []string, mailboxes []string) map[string]error {
+
+	order := make([]string, 0, len(mailboxes))
+	routed := make(map[string][]string)
+	for i, mailbox := range mailboxes {
+		if _, ok := routed[mailbox]; !ok {
+			order = append(order, mailbox)
+			routed[mailbox] = []string{}
+		}
+		if i < len(recipients) {
+			routed[mailbox] = append(routed[mailbox], recipients[i])
+		}
+	}
+
+	results := make(map[string]error)
+	for _, mailbox := range order {
+		results[mailbox] = deliverEmail(raw, sourceKey, received, mailbox,
+			routed[mailbox])
+	}
+	return results
+}
+
+// deliverEmail parses a raw message and stores it in a mailbox. Envelope
+// recipients missing from the headers are recorded as BCC, so the mailbox
+// owner can access the message. Messages which can't be parsed, or which the
+// database rejects whenever they're stored, are quarantined, which counts as
+// a successful delivery. received is the time the message reached us
+func deliverEmail(raw []byte, sourceKey string, received time.Time,
+	mailbox string, recipients []string) error {
+
+	email, err := utils.ParseEmail(string(raw))
+	if err != nil {
+		return quarantineMessage(raw, sourceKey, mailbox, err.Error())
+	}
+
+	email.Mailbox = mailbox
+	email.Received = received
+	if constants.DKIMVerifyEnable && len(email.DKIMVerdict) == 0 {
+		utils.VerifyEmailDKIM(email, dkimResolver)
+	}
+	for _, recipient := range recipients {
+		found := false
+		for _, slice := range [][]models.Recipient{email.To, email.CC,
+			email.BCC} {
+			for _, address := range slice {
+				if strings.EqualFold(address.EmailAddress, recipient) {
+					found = true
+				}
+			}
 		}
-		email := utils.ParseEmail(string(body))
-		if saveEmail(email, svc) == nil {
-			// We successfully downloaded the email... remove it from S3
-			svc.DeleteObject(&s3.DeleteObjectInput{
-				Bucket: aws.String(constants.S3Bucket),
-				Key:    objectInfo.Key,
-			})
+		if !found {
+			email.BCC = append(email.BCC,
+				models.Recipient{EmailAddress: recipient})
 		}
 	}
+
+	err = saveEmail(email)
+	if models.IsPermanentError(err) {
+		return quarantineMessage(raw, sourceKey, mailbox,
+			"Message could not be stored: "+err.Error())
+	}
+	return err
 }
 
-func saveEmail(email *models.Email, svc *s3.S3) error {
-	err := email.Create(Base.DB)
+// saveEmail stores an email and uploads its attachments. Saving an email the
+// mailbox already holds does nothing, and is not treated as an error
+func saveEmail(email *models.Email) error {
+	defer utils.RemoveTempFiles(email)
+
+	exists, err := models.EmailExists(Base.DB, email.Mailbox, email.MessageID,
+		email.SourceHash)
 	if err != nil {
 		fmt.Println(err.Error())
 		return err
 	}
+	if exists {
+		return nil
+	}
+
+	err = email.Create(Base.DB)
+	if err == models.ErrDuplicateEmail {
+		return nil
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		return err
+	}
+
+	storeEmailFiles(email)
+	return nil
+}
+
+// storeEmailFiles uploads the original source and attachments of a stored
+// email, and of every email attached to it
+func storeEmailFiles(email *models.Email) {
+	rawPath := "originals/" + strconv.Itoa(email.ID) + ".eml"
+	err := utils.StoreFile(rawPath, "message/rfc822", email.RawSource)
+	if err == nil {
+		err = email.SetRawPath(Base.DB, rawPath)
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+
 	for _, attachment := range email.Attachments {
 		fileName := "attachments/" + strconv.Itoa(email.ID) + "_attachment_" +
 			strconv.Itoa(attachment.ID)
-		_, err := svc.PutObject(&s3.PutObjectInput{
-			Bucket:      aws.String(constants.S3Bucket),
-			Key:         aws.String(fileName),
-			ContentType: aws.String(attachment.ContentType),
-			Body:        bytes.NewReader(attachment.RawData),
-		})
+		if len(attachment.TempPath) > 0 {
+			err = utils.StoreTempFile(fileName, attachment.ContentType,
+				attachment.TempPath)
+		} else {
+			err = utils.StoreFile(fileName, attachment.ContentType,
+				attachment.RawData)
+		}
 		if err == nil {
 			attachment.FilePath = fileName
 			err = attachment.Save(Base.DB)
@@ -79,5 +221,46 @@ func saveEmail(email *models.Email, svc *s3.S3) error {
 			}
 		}
 	}
-	return nil
+
+	for i := range email.Children {
+		storeEmailFiles(&email.Children[i])
+	}
+}
+
+// IngestObject delivers a single message from the mail source right away,
+// e.g. when notified of its arrival. recipients are the envelope recipients
+// of the message. If none of them map to a mailbox, the mailbox is taken
+// from the key prefix, as it is when polling
+func IngestObject(key string, recipients []string) error {
+	ingestSlots <- struct{}{}
+	defer func() { <-ingestSlots }()
+
+	body, received, err := Base.Source.Fetch(key)
+	if err != nil {
+		return err
+	}
+
+	accepted := make([]string, 0, len(recipients))
+	mailboxes := make([]string, 0, len(recipients))
+	for _, recipient := range recipients {
+		if mailbox, ok := mailboxForAddress(recipient); ok {
+			accepted = append(accepted, recipient)
+			mailboxes = append(mailboxes, mailbox)
+		}
+	}
+	if len(mailboxes) == 0 {
+		mailbox := key
+		if idx := strings.Index(key, "/"); idx > -1 {
+			mailbox = key[:idx]
+		}
+		mailboxes = append(mailboxes, mailbox)
+	}
+
+	for _, err := range deliverRaw(body, key, received, accepted,
+		mailboxes) {
+		if err != nil {
+			return err
+		}
+	}
+	return Base.Source.Acknowledge(key)
 }
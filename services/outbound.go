@@ -0,0 +1,56 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/smtp"
+	"strings"
+
+	"github.com/anishmgoyal/calagora-admin/constants"
+	"github.com/anishmgoyal/calagora-admin/models"
+)
+
+// ErrSendingDisabled is returned when sending a message while sending
+// emails is disabled, or no SMTP server is configured
+var ErrSendingDisabled = errors.New("Sending email is disabled, so nothing " +
+	"was sent")
+
+// SendEmail sends a raw message through the configured SMTP server. If
+// sending emails is disabled, the message is logged instead and
+// ErrSendingDisabled is returned
+func SendEmail(from string, to []string, raw []byte) error {
+	if !constants.DoSendEmails || len(constants.SMTPHostname) == 0 {
+		fmt.Println("[OUTBOUND] Sending disabled, not sending mail from " +
+			from + " to " + strings.Join(to, ", "))
+		return ErrSendingDisabled
+	}
+
+	var auth smtp.Auth
+	if len(constants.SMTPAuthUser) > 0 {
+		auth = smtp.PlainAuth("", constants.SMTPAuthUser,
+			constants.SMTPAuthPassword, constants.SMTPHostname)
+	}
+
+	err := smtp.SendMail(constants.SMTPHostname+":"+constants.SMTPPort, auth,
+		from, to, raw)
+	if err != nil {
+		fmt.Println("[OUTBOUND] Failed to send mail from " + from + ": " +
+			err.Error())
+	}
+	return err
+}
+
+// mailboxAddress gets the address mail from a mailbox is sent from, which is
+// the address the routing table gives it, or else one named after it
+func mailboxAddress(mailbox string) string {
+	address, err := models.GetMailboxAddress(Base.DB, mailbox)
+	if err == nil && len(address) > 0 {
+		return address
+	}
+	if strings.Compare(mailbox, constants.SupportMailbox) == 0 {
+		return constants.SupportEmail
+	}
+	domain := strings.TrimSpace(strings.Split(constants.InboundSMTPDomains,
+		",")[0])
+	return mailbox + "@" + domain
+}
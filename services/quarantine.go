@@ -0,0 +1,86 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/anishmgoyal/calagora-admin/models"
+	"github.com/anishmgoyal/calagora-admin/utils"
+)
+
+// quarantineMessage keeps a message which could not be parsed, along with
+// the reason it failed, so that it can be inspected later. Once it's
+// quarantined, the message can be acknowledged. A message the mailbox has
+// already quarantined, e.g. because acknowledging it failed, is not kept again
+func quarantineMessage(raw []byte, sourceKey string, mailbox string,
+	reason string) error {
+
+	hash := sha256.Sum256(raw)
+	sourceHash := hex.EncodeToString(hash[:])
+	exists, err := models.QuarantineExists(Base.DB, mailbox, sourceHash)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	fmt.Println("[INGEST] Quarantining message " + sourceKey + ": " + reason)
+
+	// The file is stored first, so a failure leaves nothing to clean up and
+	// the message is simply collected again
+	q := models.QuarantinedMessage{
+		Mailbox:    mailbox,
+		SourceKey:  sourceKey,
+		SourceHash: sourceHash,
+		Reason:     reason,
+		FilePath:   "quarantine/" + mailbox + "/" + sourceHash + ".eml",
+		Created:    time.Now(),
+	}
+	if err = utils.StoreFile(q.FilePath, "message/rfc822", raw); err != nil {
+		return err
+	}
+	if err = q.Create(Base.DB); err != nil {
+		utils.DeleteFile(q.FilePath)
+		return err
+	}
+	return nil
+}
+
+// ReparseQuarantined attempts to parse a quarantined message again. If it
+// succeeds, the message is delivered to its mailbox and leaves quarantine
+func ReparseQuarantined(q *models.QuarantinedMessage) error {
+	raw, err := utils.LoadFile(q.FilePath)
+	if err != nil {
+		return err
+	}
+
+	email, err := utils.ParseEmail(string(raw))
+	if err != nil {
+		q.Reason = err.Error()
+		q.Save(Base.DB)
+		return err
+	}
+
+	email.Mailbox = q.Mailbox
+	// Messages are quarantined as soon as they're received
+	email.Received = q.Created
+	if err = saveEmail(email); err != nil {
+		if models.IsPermanentError(err) {
+			q.Reason = "Message could not be stored: " + err.Error()
+			q.Save(Base.DB)
+		}
+		return err
+	}
+	return DiscardQuarantined(q)
+}
+
+// DiscardQuarantined permanently removes a quarantined message
+func DiscardQuarantined(q *models.QuarantinedMessage) error {
+	if err := utils.DeleteFile(q.FilePath); err != nil {
+		return err
+	}
+	return q.Delete(Base.DB)
+}
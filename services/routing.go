@@ -0,0 +1,105 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/anishmgoyal/calagora-admin/constants"
+	"github.com/anishmgoyal/calagora-admin/models"
+)
+
+// mailboxForAddress finds the mailbox which mail for an address should be
+// delivered to, using the routing table. Returns false if the address is not
+// accepted here
+func mailboxForAddress(address string) (string, bool) {
+	idx := strings.LastIndex(address, "@")
+	if idx < 0 {
+		return "", false
+	}
+	domain := address[idx+1:]
+
+	accepted := false
+	for _, d := range strings.Split(constants.InboundSMTPDomains, ",") {
+		if strings.EqualFold(strings.TrimSpace(d), domain) {
+			accepted = true
+			break
+		}
+	}
+	if !accepted {
+		return "", false
+	}
+
+	mailbox, err := models.ResolveMailbox(Base.DB, address)
+	if err != nil || len(mailbox) == 0 {
+		return "", false
+	}
+	return mailbox, true
+}
+
+// routedHeaders are the headers naming the recipients of a polled message,
+// in their canonical form
+var routedHeaders = []string{"To", "Cc", "Delivered-To", "X-Original-To"}
+
+// routeMessage decides where a message polled for a mailbox is delivered.
+// The source has no envelope, so each recipient named in the headers which
+// the routing table accepts gets a copy, along with the mailbox it was
+// collected for. Returns the routed recipients and their mailboxes, as
+// deliverRaw expects them
+func routeMessage(raw []byte, mailbox string) ([]string, []string) {
+	recipients := []string{}
+	mailboxes := []string{}
+
+	message, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err == nil {
+		for _, key := range routedHeaders {
+			for _, value := range message.Header[key] {
+				addresses, err := mail.ParseAddressList(value)
+				if err != nil {
+					continue
+				}
+				for _, address := range addresses {
+					routed, ok := mailboxForAddress(address.Address)
+					if ok {
+						recipients = append(recipients, address.Address)
+						mailboxes = append(mailboxes, routed)
+					}
+				}
+			}
+		}
+	}
+
+	return recipients, append(mailboxes, mailbox)
+}
+
+// AssignLegacyMailboxes moves emails stored before mail was delivered into
+// mailboxes into the mailbox of their first routed recipient
+func AssignLegacyMailboxes() {
+	ids, err := models.GetUnassignedEmailIDs(Base.DB)
+	if err != nil {
+		fmt.Println("[ROUTING] " + err.Error())
+		return
+	}
+
+	for _, id := range ids {
+		email, err := models.GetEmailByID(Base.DB, id)
+		if err != nil || email == nil {
+			continue
+		}
+
+		for _, slice := range [][]models.Recipient{email.To, email.CC,
+			email.BCC} {
+			for _, recipient := range slice {
+				if len(email.Mailbox) > 0 {
+					break
+				}
+				mailbox, err := models.ResolveMailbox(Base.DB,
+					recipient.EmailAddress)
+				if err == nil && len(mailbox) > 0 {
+					email.SetMailbox(Base.DB, mailbox)
+				}
+			}
+		}
+	}
+}
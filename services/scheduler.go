@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/anishmgoyal/calagora-admin/constants"
+	"github.com/anishmgoyal/calagora-admin/models"
+)
+
+// pollRequests wakes the scheduler early, e.g. when a user logs in
+var pollRequests = make(chan struct{}, 1)
+
+// RunScheduler polls the mail source for every mailbox on the configured
+// interval until ctx is cancelled. A cycle in progress when ctx is cancelled
+// stops after the messages currently being ingested are saved
+func RunScheduler(ctx context.Context) {
+	for {
+		pollAllMailboxes(ctx)
+
+		wait := time.Duration(constants.IngestInterval) * time.Second
+		if constants.IngestJitter > 0 {
+			wait += time.Duration(rand.Int63n(
+				int64(constants.IngestJitter) * int64(time.Second)))
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-pollRequests:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// RequestPoll asks the scheduler to poll all mailboxes as soon as possible,
+// without waiting for a poll to happen
+func RequestPoll() {
+	select {
+	case pollRequests <- struct{}{}:
+	default:
+		// A poll has already been requested
+	}
+}
+
+func pollAllMailboxes(ctx context.Context) {
+	// Every user's mailbox is polled, along with every mailbox in the
+	// routing table, such as shared ones
+	usernames, err := models.GetUsernames(Base.DB)
+	if err != nil {
+		fmt.Println("[INGEST] Failed to load mailboxes: " + err.Error())
+		usernames = []string{}
+	}
+	routed, err := models.GetRoutedMailboxes(Base.DB)
+	if err != nil {
+		fmt.Println("[INGEST] Failed to load routed mailboxes: " +
+			err.Error())
+		routed = []string{}
+	}
+
+	polled := make(map[string]bool)
+	var wg sync.WaitGroup
+	for _, mailbox := range append(routed, usernames...) {
+		if ctx.Err() != nil {
+			break
+		}
+		if polled[mailbox] {
+			continue
+		}
+		polled[mailbox] = true
+
+		wg.Add(1)
+		go func(mailbox string) {
+			defer wg.Done()
+			DownloadEmail(ctx, mailbox)
+		}(mailbox)
+	}
+	wg.Wait()
+}
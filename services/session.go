@@ -16,48 +16,28 @@ var sessions map[string]models.Session
 var mutex *sync.Mutex
 
 func initSessions() {
-	go sessionEvicterAndDownloader()
+	go sessionEvicter()
 	sessions = make(map[string]models.Session)
 	mutex = &sync.Mutex{}
 }
 
-// sessionEvicterAndDownloader evicts old sessions, and attempts to
-// download new emails for active users
-func sessionEvicterAndDownloader() {
+// sessionEvicter periodically evicts old sessions
+func sessionEvicter() {
 	for {
 		time.Sleep(time.Second * 15)
 		var toRemove []string
 
-		emailDownloadQueue := make(map[int]int)
-
 		// Thread-safe session eviction
 		mutex.Lock()
 		for k, v := range sessions {
 			if v.Modified.Unix() < time.Now().AddDate(0, 0, -1).Unix() {
 				toRemove = append(toRemove, k)
-			} else {
-				emailDownloadQueue[v.UserID] = v.UserID
 			}
 		}
 		for _, k := range toRemove {
 			delete(sessions, k)
 		}
 		mutex.Unlock()
-
-		go emailDownloaderForActiveUsers(emailDownloadQueue)
-	}
-}
-
-func emailDownloaderForActiveUsers(toDownload map[int]int) {
-	events := make(chan string)
-	go DownloadEmailForUser("support", events)
-	for k := range toDownload {
-		user, err := models.GetUserByID(Base.DB, k)
-		if err != nil || user == nil {
-			continue
-		}
-		<-events
-		go DownloadEmailForUser(user.Username, events)
 	}
 }
 
@@ -0,0 +1,495 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anishmgoyal/calagora-admin/constants"
+)
+
+const (
+	smtpTimeout       = time.Minute * 5
+	smtpMaxRecipients = 100
+)
+
+// mailServers tracks the SMTP and LMTP listeners and their sessions, so they
+// can be drained on shutdown. Each session maps to whether it's idle, waiting
+// on the client for a command
+var mailServers = struct {
+	sync.Mutex
+	closing   bool
+	listeners []net.Listener
+	sessions  map[*smtpSession]bool
+	wg        sync.WaitGroup
+}{sessions: make(map[*smtpSession]bool)}
+
+// smtpSession tracks the state of a single inbound SMTP connection
+type smtpSession struct {
+	// raw is the connection as accepted, before any TLS
+	raw        net.Conn
+	conn       net.Conn
+	text       *textproto.Conn
+	tlsConfig  *tls.Config
+	lmtp       bool
+	secure     bool
+	helo       string
+	from       string
+	hasFrom    bool
+	recipients []string
+	mailboxes  []string
+}
+
+// ListenForSMTP accepts mail over SMTP on addr, feeding each message through
+// the same parse and save path used for the mail source
+func ListenForSMTP(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return serveSMTP(listener, smtpTLSConfig(), false)
+}
+
+// ListenForLMTP accepts mail over LMTP, for use as the final delivery agent
+// behind an MTA such as Postfix. network is either "tcp" or "unix"
+func ListenForLMTP(network string, addr string) error {
+	if network == "unix" {
+		// Clean up a socket left behind by a previous run
+		os.Remove(addr)
+	}
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	return serveSMTP(listener, nil, true)
+}
+
+func serveSMTP(listener net.Listener, tlsConfig *tls.Config, lmtp bool) error {
+	defer listener.Close()
+
+	mailServers.Lock()
+	if mailServers.closing {
+		mailServers.Unlock()
+		return errors.New("Mail listeners are shut down")
+	}
+	mailServers.listeners = append(mailServers.listeners, listener)
+	mailServers.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		mailServers.Lock()
+		if mailServers.closing {
+			mailServers.Unlock()
+			conn.Close()
+			continue
+		}
+		mailServers.wg.Add(1)
+		mailServers.Unlock()
+		go handleSMTP(conn, tlsConfig, lmtp)
+	}
+}
+
+// ShutdownMailListeners stops accepting mail over SMTP and LMTP, and waits
+// for open sessions to finish. Idle sessions are closed straight away, while
+// sessions delivering a message finish delivering it. If ctx ends first, the
+// remaining sessions are closed and ctx's error is returned
+func ShutdownMailListeners(ctx context.Context) error {
+	mailServers.Lock()
+	mailServers.closing = true
+	for _, listener := range mailServers.listeners {
+		listener.Close()
+	}
+	for s, idle := range mailServers.sessions {
+		if idle {
+			// Wakes the session, which then sees we're closing
+			s.raw.SetReadDeadline(time.Now())
+		}
+	}
+	mailServers.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		mailServers.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		mailServers.Lock()
+		for s := range mailServers.sessions {
+			s.raw.Close()
+		}
+		mailServers.Unlock()
+		return ctx.Err()
+	}
+}
+
+// track records a session as open, returning false if we're shutting down
+func (s *smtpSession) track() bool {
+	mailServers.Lock()
+	defer mailServers.Unlock()
+	if mailServers.closing {
+		return false
+	}
+	mailServers.sessions[s] = false
+	return true
+}
+
+func (s *smtpSession) untrack() {
+	mailServers.Lock()
+	delete(mailServers.sessions, s)
+	mailServers.Unlock()
+	mailServers.wg.Done()
+}
+
+// awaitCommand marks the session idle and extends its deadline, unless
+// we're shutting down. Returns false if the session should be closed
+func (s *smtpSession) awaitCommand() bool {
+	mailServers.Lock()
+	defer mailServers.Unlock()
+	if mailServers.closing {
+		return false
+	}
+	mailServers.sessions[s] = true
+	s.conn.SetDeadline(time.Now().Add(smtpTimeout))
+	return true
+}
+
+// busy marks the session as handling a command, which is allowed to finish
+// on shutdown
+func (s *smtpSession) busy() {
+	mailServers.Lock()
+	mailServers.sessions[s] = false
+	mailServers.Unlock()
+}
+
+// smtpTLSConfig loads the site certificate for STARTTLS, if one is configured
+func smtpTLSConfig() *tls.Config {
+	if len(constants.SSLCertificate) == 0 || len(constants.SSLKeyFile) == 0 {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(constants.SSLCertificate,
+		constants.SSLKeyFile)
+	if err != nil {
+		fmt.Println("[SMTP] STARTTLS disabled: " + err.Error())
+		return nil
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}
+}
+
+func handleSMTP(conn net.Conn, tlsConfig *tls.Config, lmtp bool) {
+	s := &smtpSession{
+		raw:       conn,
+		conn:      conn,
+		text:      textproto.NewConn(conn),
+		tlsConfig: tlsConfig,
+		lmtp:      lmtp,
+	}
+	defer s.untrack()
+	defer s.text.Close()
+	if !s.track() {
+		s.reply(421, "4.3.2 Service shutting down")
+		return
+	}
+
+	if lmtp {
+		s.reply(220, constants.InboundSMTPHostname+" LMTP ready")
+	} else {
+		s.reply(220, constants.InboundSMTPHostname+" ESMTP ready")
+	}
+	for {
+		if !s.awaitCommand() {
+			s.reply(421, "4.3.2 Service shutting down")
+			return
+		}
+		line, err := s.text.ReadLine()
+		if err != nil {
+			if isShuttingDown() {
+				s.reply(421, "4.3.2 Service shutting down")
+			}
+			return
+		}
+		s.busy()
+
+		verb, arg := line, ""
+		if idx := strings.Index(line, " "); idx > -1 {
+			verb, arg = line[:idx], strings.TrimSpace(line[idx+1:])
+		}
+
+		switch verb = strings.ToUpper(verb); verb {
+		case "HELO", "EHLO", "LHLO":
+			if s.lmtp != (verb == "LHLO") {
+				s.reply(500, "5.5.1 Command not recognized")
+				continue
+			}
+			s.reset()
+			s.helo = arg
+			if verb == "HELO" {
+				s.reply(250, constants.InboundSMTPHostname)
+			} else {
+				s.ehlo()
+			}
+		case "STARTTLS":
+			if !s.startTLS() {
+				return
+			}
+		case "MAIL":
+			s.mail(arg)
+		case "RCPT":
+			s.rcpt(arg)
+		case "DATA":
+			s.data()
+		case "RSET":
+			s.reset()
+			s.reply(250, "2.0.0 OK")
+		case "NOOP":
+			s.reply(250, "2.0.0 OK")
+		case "VRFY":
+			s.reply(252, "2.5.0 Cannot verify user")
+		case "QUIT":
+			s.reply(221, "2.0.0 Bye")
+			return
+		default:
+			s.reply(500, "5.5.2 Command not recognized")
+		}
+	}
+}
+
+func isShuttingDown() bool {
+	mailServers.Lock()
+	defer mailServers.Unlock()
+	return mailServers.closing
+}
+
+func (s *smtpSession) reply(code int, message string) {
+	s.text.PrintfLine("%d %s", code, message)
+}
+
+func (s *smtpSession) reset() {
+	s.from = ""
+	s.hasFrom = false
+	s.recipients = nil
+	s.mailboxes = nil
+}
+
+func (s *smtpSession) ehlo() {
+	lines := []string{
+		constants.InboundSMTPHostname,
+		"PIPELINING",
+		"8BITMIME",
+		"SIZE " + strconv.Itoa(constants.InboundSMTPMaxSize),
+	}
+	if s.tlsConfig != nil && !s.secure {
+		lines = append(lines, "STARTTLS")
+	}
+	for i, line := range lines {
+		separator := "-"
+		if i == len(lines)-1 {
+			separator = " "
+		}
+		s.text.PrintfLine("250%s%s", separator, line)
+	}
+}
+
+// startTLS upgrades the connection. Returns false if the connection can no
+// longer be used
+func (s *smtpSession) startTLS() bool {
+	if s.tlsConfig == nil || s.secure {
+		s.reply(454, "4.7.0 TLS not available")
+		return true
+	}
+	s.reply(220, "2.0.0 Ready to start TLS")
+
+	tlsConn := tls.Server(s.conn, s.tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		return false
+	}
+	s.conn = tlsConn
+	s.text = textproto.NewConn(tlsConn)
+	s.secure = true
+
+	// Clients must greet us again after negotiating TLS
+	s.helo = ""
+	s.reset()
+	return true
+}
+
+func (s *smtpSession) mail(arg string) {
+	if len(s.helo) == 0 {
+		s.reply(503, "5.5.1 Send HELO or EHLO first")
+		return
+	}
+	if s.hasFrom {
+		s.reply(503, "5.5.1 Sender already specified")
+		return
+	}
+	from, params, ok := parseSMTPPath(arg, "FROM:")
+	if !ok {
+		s.reply(501, "5.5.4 Syntax: MAIL FROM:<address>")
+		return
+	}
+	if size, ok := params["SIZE"]; ok {
+		n, err := strconv.Atoi(size)
+		if err == nil && n > constants.InboundSMTPMaxSize {
+			s.reply(552, "5.3.4 Message size exceeds fixed limit")
+			return
+		}
+	}
+	s.from = from
+	s.hasFrom = true
+	s.reply(250, "2.1.0 OK")
+}
+
+func (s *smtpSession) rcpt(arg string) {
+	if !s.hasFrom {
+		s.reply(503, "5.5.1 Need MAIL before RCPT")
+		return
+	}
+	to, _, ok := parseSMTPPath(arg, "TO:")
+	if !ok || len(to) == 0 {
+		s.reply(501, "5.5.4 Syntax: RCPT TO:<address>")
+		return
+	}
+	if len(s.recipients) >= smtpMaxRecipients {
+		s.reply(452, "4.5.3 Too many recipients")
+		return
+	}
+	mailbox, ok := mailboxForAddress(to)
+	if !ok {
+		s.reply(550, "5.1.1 No such user here")
+		return
+	}
+	s.recipients = append(s.recipients, to)
+	s.mailboxes = append(s.mailboxes, mailbox)
+	s.reply(250, "2.1.5 OK")
+}
+
+func (s *smtpSession) data() {
+	if !s.hasFrom || len(s.recipients) == 0 {
+		s.reply(503, "5.5.1 Need RCPT before DATA")
+		return
+	}
+	s.reply(354, "Start mail input; end with <CRLF>.<CRLF>")
+
+	raw, ok := s.readData()
+	if raw == nil {
+		return
+	}
+	if !ok {
+		replies := 1
+		if s.lmtp {
+			replies = len(s.recipients)
+		}
+		for i := 0; i < replies; i++ {
+			s.reply(552, "5.3.4 Message size exceeds fixed limit")
+		}
+		s.reset()
+		return
+	}
+
+	raw = receivedMessage(raw, s.lmtp)
+	results := deliverRaw(raw, "", time.Now(), s.recipients, s.mailboxes)
+	if s.lmtp {
+		// LMTP gives a status for each accepted recipient, in order
+		for i, recipient := range s.recipients {
+			if results[s.mailboxes[i]] != nil {
+				s.reply(451, "4.3.0 <"+recipient+"> Failed to store message")
+			} else {
+				s.reply(250, "2.0.0 <"+recipient+"> Delivered")
+			}
+		}
+		s.reset()
+		return
+	}
+
+	for _, err := range results {
+		if err != nil {
+			s.reset()
+			s.reply(451, "4.3.0 Failed to store message")
+			return
+		}
+	}
+	s.reset()
+	s.reply(250, "2.0.0 Message accepted for delivery")
+}
+
+// receivedMessage prepares a message received over SMTP or LMTP for
+// delivery. Mail sent to us over SMTP was checked by no trusted server, so an
+// Authentication-Results header saying so goes on top of any the sender
+// added. Over LMTP, the MTA delivering to us has already added its own
+func receivedMessage(raw []byte, lmtp bool) []byte {
+	if lmtp {
+		return raw
+	}
+	return append([]byte("Authentication-Results: "+
+		constants.InboundSMTPHostname+"; none\n"), raw...)
+}
+
+// readData reads a message terminated by a lone dot. Returns nil if the
+// connection failed, and false if the message was too large
+func (s *smtpSession) readData() ([]byte, bool) {
+	reader := s.text.DotReader()
+	raw, err := ioutil.ReadAll(io.LimitReader(reader,
+		int64(constants.InboundSMTPMaxSize)+1))
+	if err != nil {
+		return nil, false
+	}
+	if len(raw) > constants.InboundSMTPMaxSize {
+		if _, err = io.Copy(ioutil.Discard, reader); err != nil {
+			return nil, false
+		}
+		return []byte{}, false
+	}
+	return raw, true
+}
+
+// parseSMTPPath reads the address and any parameters out of the argument to
+// MAIL or RCPT, e.g. "FROM:<a@b.com> SIZE=100"
+func parseSMTPPath(arg string, prefix string) (string, map[string]string,
+	bool) {
+
+	if len(arg) < len(prefix) ||
+		!strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", nil, false
+	}
+	arg = strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(arg, "<") {
+		return "", nil, false
+	}
+	end := strings.Index(arg, ">")
+	if end < 0 {
+		return "", nil, false
+	}
+
+	address := arg[1:end]
+	// Strip any source route, e.g. <@relay.com:user@host.com>
+	if idx := strings.LastIndex(address, ":"); idx > -1 {
+		address = address[idx+1:]
+	}
+
+	params := make(map[string]string)
+	for _, param := range strings.Fields(arg[end+1:]) {
+		key, value := param, ""
+		if idx := strings.Index(param, "="); idx > -1 {
+			key, value = param[:idx], param[idx+1:]
+		}
+		params[strings.ToUpper(key)] = value
+	}
+	return address, params, true
+}
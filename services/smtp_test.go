@@ -0,0 +1,52 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/anishmgoyal/calagora-admin/constants"
+	"github.com/anishmgoyal/calagora-admin/utils"
+)
+
+func TestReceivedMessage(t *testing.T) {
+	defer func(ids, hostname string) {
+		constants.AuthServIDs, constants.InboundSMTPHostname = ids, hostname
+	}(constants.AuthServIDs, constants.InboundSMTPHostname)
+	constants.AuthServIDs = "mta.calagora.com"
+	constants.InboundSMTPHostname = "mx.calagora.com"
+
+	const message = "Authentication-Results: mta.calagora.com; " +
+		"spf=pass smtp.mailfrom=a@example.com; " +
+		"dkim=pass header.d=example.com; dmarc=pass header.from=example.com\n" +
+		"From: a@example.com\n" +
+		"Subject: Test\n" +
+		"\n" +
+		"Body\n"
+
+	tests := []struct {
+		name     string
+		lmtp     bool
+		expected string
+	}{
+		// Over SMTP, the header came from the sender, however it's named
+		{"SMTP", false, ""},
+		// Over LMTP, it came from the MTA delivering to us
+		{"LMTP", true, "pass"},
+	}
+
+	for _, test := range tests {
+		email, err := utils.ParseEmail(string(receivedMessage([]byte(message),
+			test.lmtp)))
+		if err != nil {
+			t.Errorf("%s: failed to parse: %s", test.name, err.Error())
+			continue
+		}
+		verdicts := map[string]string{"SPF": email.SPFVerdict,
+			"DKIM": email.DKIMVerdict, "DMARC": email.DMARCVerdict}
+		for method, verdict := range verdicts {
+			if verdict != test.expected {
+				t.Errorf("%s: %s verdict is %q, expected %q", test.name, method,
+					verdict, test.expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package sources
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DirectorySource collects messages from a local directory, for development
+// machines and tests without access to AWS. Messages for a mailbox are files
+// inside a subdirectory named after the mailbox, e.g. <root>/support/msg1
+type DirectorySource struct {
+	root string
+}
+
+// NewDirectorySource creates a source reading from the given directory
+func NewDirectorySource(root string) *DirectorySource {
+	return &DirectorySource{root: root}
+}
+
+// List finds the keys of any messages waiting to be delivered to mailbox
+func (d *DirectorySource) List(mailbox string) ([]string, error) {
+	files, err := ioutil.ReadDir(filepath.Join(d.root, mailbox))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		keys = append(keys, mailbox+"/"+file.Name())
+	}
+	return keys, nil
+}
+
+// Fetch retrieves the raw contents of a message by its key. The file's
+// modification time is used as the time it was received
+func (d *DirectorySource) Fetch(key string) ([]byte, time.Time, error) {
+	info, err := os.Stat(d.path(key))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	body, err := ioutil.ReadFile(d.path(key))
+	return body, info.ModTime(), err
+}
+
+// Acknowledge removes a delivered message from the directory
+func (d *DirectorySource) Acknowledge(key string) error {
+	return os.Remove(d.path(key))
+}
+
+// path maps a key onto the filesystem, refusing to leave the root directory
+func (d *DirectorySource) path(key string) string {
+	return filepath.Join(d.root, filepath.FromSlash(filepath.Clean("/"+key)))
+}
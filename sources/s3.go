@@ -0,0 +1,76 @@
+package sources
+
+import (
+	"io/ioutil"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// S3Source collects messages dropped into an S3 bucket by SES. Messages for
+// a mailbox are stored with the mailbox name as their key prefix
+type S3Source struct {
+	bucket string
+	svc    *s3.S3
+}
+
+// NewS3Source creates a source reading from the given bucket
+func NewS3Source(bucket string, region string) *S3Source {
+	return &S3Source{
+		bucket: bucket,
+		svc: s3.New(session.New(), &aws.Config{
+			Region: aws.String(region),
+		}),
+	}
+}
+
+// List finds the keys of any messages waiting to be delivered to mailbox,
+// following continuation tokens until every page has been read
+func (s *S3Source) List(mailbox string) ([]string, error) {
+	keys := make([]string, 0, 100)
+	err := s.svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(mailbox),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, objectInfo := range page.Contents {
+			keys = append(keys, *objectInfo.Key)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Fetch retrieves the raw contents of a message by its key. SES writes each
+// message as soon as it's received, so the object's modification time is
+// used as the time it was received
+func (s *S3Source) Fetch(key string) ([]byte, time.Time, error) {
+	object, err := s.svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer object.Body.Close()
+
+	received := time.Now()
+	if object.LastModified != nil {
+		received = *object.LastModified
+	}
+	body, err := ioutil.ReadAll(object.Body)
+	return body, received, err
+}
+
+// Acknowledge removes a delivered message from the bucket
+func (s *S3Source) Acknowledge(key string) error {
+	_, err := s.svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
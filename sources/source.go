@@ -0,0 +1,29 @@
+package sources
+
+import (
+	"time"
+
+	"github.com/anishmgoyal/calagora-admin/constants"
+)
+
+// MailSource is a place from which raw, unparsed messages can be collected
+// and delivered into a mailbox
+type MailSource interface {
+	// List finds the keys of any messages waiting to be delivered to mailbox
+	List(mailbox string) ([]string, error)
+	// Fetch retrieves the raw contents of a message by its key, along with
+	// the time the source received it
+	Fetch(key string) ([]byte, time.Time, error)
+	// Acknowledge marks a message as delivered so it is not listed again
+	Acknowledge(key string) error
+}
+
+// Default builds the mail source selected by the environment settings
+func Default() MailSource {
+	switch constants.MailSourceType {
+	case "directory":
+		return NewDirectorySource(constants.MailDirectory)
+	default:
+		return NewS3Source(constants.S3Bucket, constants.S3RegionString)
+	}
+}
@@ -1,34 +1,39 @@
 package utils
 
 import (
-	"io/ioutil"
+	"bytes"
+	"os"
 
-	"github.com/anishmgoyal/calagora-admin/constants"
 	"github.com/anishmgoyal/calagora-admin/models"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
 )
 
-// LoadAttachment attempts to fetch the body of an attachment from S3
+// LoadAttachment attempts to fetch the body of an attachment from storage
 func LoadAttachment(attachment models.Attachment) ([]byte, error) {
-	svc := s3.New(session.New(), &aws.Config{
-		Region: aws.String(constants.S3RegionString),
-	})
+	return LoadFile(attachment.FilePath)
+}
 
-	out, err := svc.GetObject(&s3.GetObjectInput{
-		Bucket: aws.String(constants.S3Bucket),
-		Key:    aws.String(attachment.FilePath),
-	})
+// StoreFile attempts to save a file to storage
+func StoreFile(path string, contentType string, body []byte) error {
+	return fileStore.Store(path, contentType, bytes.NewReader(body))
+}
 
+// StoreTempFile attempts to save a file from disk to storage, without
+// reading it into memory
+func StoreTempFile(path string, contentType string, tempPath string) error {
+	file, err := os.Open(tempPath)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer file.Close()
+	return fileStore.Store(path, contentType, file)
+}
 
-	body, err := ioutil.ReadAll(out.Body)
-	if err != nil {
-		return nil, err
-	}
+// LoadFile attempts to fetch the body of a file from storage
+func LoadFile(path string) ([]byte, error) {
+	return fileStore.Load(path)
+}
 
-	return body, nil
+// DeleteFile attempts to remove a file from storage
+func DeleteFile(path string) error {
+	return fileStore.Delete(path)
 }
@@ -0,0 +1,188 @@
+package utils
+
+import (
+	"strings"
+
+	"github.com/anishmgoyal/calagora-admin/constants"
+	"github.com/anishmgoyal/calagora-admin/models"
+)
+
+// sesVerdicts maps the verdicts SES records in X-SES-*-Verdict headers to
+// Authentication-Results results
+var sesVerdicts = map[string]string{
+	"PASS":              models.AuthResultPass,
+	"FAIL":              models.AuthResultFail,
+	"GRAY":              models.AuthResultNone,
+	"PROCESSING_FAILED": models.AuthResultTempError,
+}
+
+// authResult is one method's result from an Authentication-Results header
+type authResult struct {
+	method     string
+	result     string
+	properties map[string]string
+}
+
+// parseAuthentication reads SPF, DKIM and DMARC verdicts from the trusted
+// Authentication-Results header of an email, falling back to the verdicts
+// SES records in its own headers. The email must already have its headers
+// and From address. Returns false if the email didn't come through a
+// trusted server, so none of the verdicts in its headers can be believed
+func parseAuthentication(email *models.Email, header headerInterface) bool {
+	results, trusted := trustedAuthResults(email.Headers)
+	for _, result := range results {
+		switch result.method {
+		case "spf":
+			if len(email.SPFVerdict) == 0 {
+				email.SPFVerdict = result.result
+				email.SPFDomain = addressDomain(result.properties["smtp.mailfrom"])
+			}
+		case "dkim":
+			// Prefer a passing signature, as any number may be checked
+			if len(email.DKIMVerdict) == 0 ||
+				(email.DKIMVerdict != models.AuthResultPass &&
+					result.result == models.AuthResultPass) {
+				email.DKIMVerdict = result.result
+				email.DKIMDomain = result.properties["header.d"]
+				if len(email.DKIMDomain) == 0 {
+					email.DKIMDomain = addressDomain(result.properties["header.i"])
+				}
+			}
+		case "dmarc":
+			if len(email.DMARCVerdict) == 0 {
+				email.DMARCVerdict = result.result
+			}
+		}
+	}
+
+	// The X-SES headers can be forged just the same, so they're only read
+	// from mail which came through a trusted server
+	sesVerdict := func(name string) string {
+		if !trusted {
+			return ""
+		}
+		return sesVerdicts[strings.ToUpper(strings.TrimSpace(header.Get(name)))]
+	}
+	if len(email.SPFVerdict) == 0 {
+		email.SPFVerdict = sesVerdict("X-SES-SPF-Verdict")
+	}
+	if len(email.DKIMVerdict) == 0 {
+		email.DKIMVerdict = sesVerdict("X-SES-DKIM-Verdict")
+	}
+	if len(email.DMARCVerdict) == 0 {
+		email.DMARCVerdict = sesVerdict("X-SES-DMARC-Verdict")
+	}
+
+	email.IsUnauthenticated = isOwnDomain(addressDomain(email.From)) &&
+		!isAligned(email)
+	return trusted
+}
+
+// trustedAuthResults gets the results of the topmost Authentication-Results
+// header, which the server that received the email added, if that server is
+// trusted. Anyone can add these headers before sending, even naming a trusted
+// server, so the rest are ignored. Returns false if the email didn't come
+// through a trusted server
+func trustedAuthResults(headers []models.Header) ([]authResult, bool) {
+	for _, header := range headers {
+		if !strings.EqualFold(header.Name, "Authentication-Results") {
+			continue
+		}
+		servID, results := parseAuthResults(header.Value)
+		if len(strings.TrimSpace(constants.AuthServIDs)) == 0 {
+			return results, true
+		}
+		for _, id := range strings.Split(constants.AuthServIDs, ",") {
+			if strings.EqualFold(servID, strings.TrimSpace(id)) {
+				return results, true
+			}
+		}
+		return nil, false
+	}
+	return nil, false
+}
+
+// parseAuthResults splits an Authentication-Results header, as described in
+// RFC 8601, into the server which added it and its results
+func parseAuthResults(value string) (string, []authResult) {
+	parts := strings.Split(stripComments(value), ";")
+	servID := ""
+	if fields := strings.Fields(parts[0]); len(fields) > 0 {
+		servID = fields[0]
+	}
+
+	results := make([]authResult, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		idx := strings.Index(fields[0], "=")
+		if idx < 1 {
+			// e.g. "none", when no methods were checked
+			continue
+		}
+
+		result := authResult{
+			method:     strings.ToLower(fields[0][:idx]),
+			result:     strings.ToLower(fields[0][idx+1:]),
+			properties: make(map[string]string),
+		}
+		for _, field := range fields[1:] {
+			if idx := strings.Index(field, "="); idx > 0 {
+				result.properties[strings.ToLower(field[:idx])] =
+					strings.Trim(field[idx+1:], "\"")
+			}
+		}
+		results = append(results, result)
+	}
+	return servID, results
+}
+
+// isAligned determines if an email's authenticated domains match the domain
+// of its From address, as DMARC requires
+func isAligned(email *models.Email) bool {
+	if email.DMARCVerdict == models.AuthResultPass {
+		return true
+	}
+	if email.DMARCVerdict == models.AuthResultFail {
+		return false
+	}
+
+	from := addressDomain(email.From)
+	return (email.SPFVerdict == models.AuthResultPass &&
+		domainsAligned(email.SPFDomain, from)) ||
+		(email.DKIMVerdict == models.AuthResultPass &&
+			domainsAligned(email.DKIMDomain, from))
+}
+
+// domainsAligned compares domains as DMARC's relaxed mode does, allowing
+// either domain to be a subdomain of the other
+func domainsAligned(a string, b string) bool {
+	a = strings.ToLower(strings.TrimSuffix(a, "."))
+	b = strings.ToLower(strings.TrimSuffix(b, "."))
+	if len(a) == 0 || len(b) == 0 {
+		return false
+	}
+	return a == b || strings.HasSuffix(a, "."+b) || strings.HasSuffix(b, "."+a)
+}
+
+// isOwnDomain determines if a domain is one we receive mail for
+func isOwnDomain(domain string) bool {
+	for _, own := range strings.Split(constants.InboundSMTPDomains, ",") {
+		if domainsAligned(domain, strings.TrimSpace(own)) {
+			return true
+		}
+	}
+	return false
+}
+
+// addressDomain gets the domain of an address, or the value itself if it's
+// already a domain
+func addressDomain(address string) string {
+	address = strings.Trim(strings.TrimSpace(address), "<>")
+	if idx := strings.LastIndex(address, "@"); idx > -1 {
+		return address[idx+1:]
+	}
+	return address
+}
@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/anishmgoyal/calagora-admin/constants"
+)
+
+func TestParseAuthentication(t *testing.T) {
+	defer func(ids, domains string) {
+		constants.AuthServIDs, constants.InboundSMTPDomains = ids, domains
+	}(constants.AuthServIDs, constants.InboundSMTPDomains)
+	constants.InboundSMTPDomains = "calagora.com"
+
+	const ses = "Authentication-Results: amazonses.com;\r\n" +
+		" spf=pass (spfCheck: domain of example.com designates 192.0.2.1 as " +
+		"permitted sender) smtp.mailfrom=bounce@example.com;\r\n" +
+		" dkim=fail header.i=@example.com;\r\n" +
+		" dmarc=pass header.from=example.com;\r\n"
+	const forged = "Authentication-Results: amazonses.com; spf=pass " +
+		"smtp.mailfrom=ceo@calagora.com; dkim=pass header.d=calagora.com; " +
+		"dmarc=pass header.from=calagora.com\r\n"
+	const sesVerdicts = "X-SES-SPF-Verdict: PASS\r\n" +
+		"X-SES-DKIM-Verdict: PASS\r\n" +
+		"X-SES-DMARC-Verdict: PASS\r\n"
+	const smtp = "Authentication-Results: mx.calagora.com; none\r\n"
+
+	tests := []struct {
+		name            string
+		servIDs         string
+		headers         string
+		from            string
+		spf, dkim       string
+		dmarc           string
+		unauthenticated bool
+	}{
+		{"trusted server", "amazonses.com", ses, "a@example.com",
+			"pass", "fail", "pass", false},
+		{"forged pass below the trusted header", "amazonses.com",
+			ses + forged, "a@example.com", "pass", "fail", "pass", false},
+		{"SES verdicts from a trusted server", "amazonses.com",
+			"Authentication-Results: amazonses.com; none\r\n" + sesVerdicts,
+			"a@example.com", "pass", "pass", "pass", false},
+		{"forged header on mail sent directly", "amazonses.com",
+			smtp + forged + sesVerdicts, "ceo@calagora.com", "", "", "", true},
+		{"forged header from an untrusted server", "amazonses.com",
+			"Authentication-Results: mx.example.net; dkim=fail\r\n" + forged,
+			"ceo@calagora.com", "", "", "", true},
+		{"forged SES verdicts without a header", "amazonses.com",
+			sesVerdicts, "ceo@calagora.com", "", "", "", true},
+		{"any topmost server when none are listed", "",
+			"Authentication-Results: mx.calagora.com; dkim=pass " +
+				"header.d=calagora.com\r\n" + forged,
+			"ceo@calagora.com", "", "pass", "", false},
+	}
+
+	for _, test := range tests {
+		constants.AuthServIDs = test.servIDs
+		email, err := ParseEmail(test.headers + "From: " + test.from + "\r\n" +
+			"Subject: Test\r\n\r\nBody\r\n")
+		if err != nil {
+			t.Errorf("%s: failed to parse: %s", test.name, err.Error())
+			continue
+		}
+		verdicts := []struct{ method, actual, expected string }{
+			{"SPF", email.SPFVerdict, test.spf},
+			{"DKIM", email.DKIMVerdict, test.dkim},
+			{"DMARC", email.DMARCVerdict, test.dmarc},
+		}
+		for _, verdict := range verdicts {
+			if verdict.actual != verdict.expected {
+				t.Errorf("%s: %s verdict is %q, expected %q", test.name,
+					verdict.method, verdict.actual, verdict.expected)
+			}
+		}
+		if email.IsUnauthenticated != test.unauthenticated {
+			t.Errorf("%s: unauthenticated is %t, expected %t", test.name,
+				email.IsUnauthenticated, test.unauthenticated)
+		}
+	}
+}
+
+func TestDomainsAligned(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected bool
+	}{
+		{"example.com", "example.com", true},
+		{"mail.example.com", "example.com", true},
+		{"example.com", "MAIL.Example.com.", true},
+		{"example.com", "badexample.com", false},
+		{"example.com", "", false},
+	}
+
+	for _, test := range tests {
+		if actual := domainsAligned(test.a, test.b); actual != test.expected {
+			t.Errorf("domainsAligned(%q, %q) = %t, expected %t", test.a, test.b,
+				actual, test.expected)
+		}
+	}
+}
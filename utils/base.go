@@ -2,5 +2,7 @@ package utils
 
 // BaseInitialization sets up utility functions
 func BaseInitialization() {
+	initStorage()
 	initEmail()
+	initSMIME()
 }
@@ -0,0 +1,645 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/anishmgoyal/calagora-admin/models"
+)
+
+// calendarProductID identifies us in iCalendar objects we generate
+const calendarProductID = "-//Calagora//Calagora Admin//EN"
+
+// windowsTimeZones maps the time zone names Outlook uses to IANA names
+var windowsTimeZones = map[string]string{
+	"Dateline Standard Time":          "Etc/GMT+12",
+	"Hawaiian Standard Time":          "Pacific/Honolulu",
+	"Alaskan Standard Time":           "America/Anchorage",
+	"Pacific Standard Time":           "America/Los_Angeles",
+	"US Mountain Standard Time":       "America/Phoenix",
+	"Mountain Standard Time":          "America/Denver",
+	"Central Standard Time":           "America/Chicago",
+	"Eastern Standard Time":           "America/New_York",
+	"US Eastern Standard Time":        "America/Indianapolis",
+	"Atlantic Standard Time":          "America/Halifax",
+	"Newfoundland Standard Time":      "America/St_Johns",
+	"E. South America Standard Time":  "America/Sao_Paulo",
+	"UTC":                             "UTC",
+	"GMT Standard Time":               "Europe/London",
+	"W. Europe Standard Time":         "Europe/Berlin",
+	"Central Europe Standard Time":    "Europe/Budapest",
+	"Romance Standard Time":           "Europe/Paris",
+	"Central European Standard Time":  "Europe/Warsaw",
+	"E. Europe Standard Time":         "Europe/Chisinau",
+	"FLE Standard Time":               "Europe/Kiev",
+	"GTB Standard Time":               "Europe/Bucharest",
+	"Russian Standard Time":           "Europe/Moscow",
+	"Israel Standard Time":            "Asia/Jerusalem",
+	"Arabian Standard Time":           "Asia/Dubai",
+	"India Standard Time":             "Asia/Calcutta",
+	"China Standard Time":             "Asia/Shanghai",
+	"Singapore Standard Time":         "Asia/Singapore",
+	"Tokyo Standard Time":             "Asia/Tokyo",
+	"Korea Standard Time":             "Asia/Seoul",
+	"AUS Eastern Standard Time":       "Australia/Sydney",
+	"E. Australia Standard Time":      "Australia/Brisbane",
+	"New Zealand Standard Time":       "Pacific/Auckland",
+	"SA Pacific Standard Time":        "America/Bogota",
+	"Central America Standard Time":   "America/Guatemala",
+	"Pacific SA Standard Time":        "America/Santiago",
+	"South Africa Standard Time":      "Africa/Johannesburg",
+	"W. Central Africa Standard Time": "Africa/Lagos",
+}
+
+// weekdays maps the day names used in recurrence rules to weekdays
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday,
+	"WE": time.Wednesday, "TH": time.Thursday, "FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// calendarProperty is a single content line of an iCalendar object
+type calendarProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// calendarZone is a time zone defined by a VTIMEZONE, as the observances it
+// switches between, e.g. standard and daylight time
+type calendarZone struct {
+	name        string
+	observances []*zoneObservance
+}
+
+// zoneObservance is a STANDARD or DAYLIGHT part of a VTIMEZONE. Its onsets
+// are wall clock times, kept in UTC, at which the zone switches to offsetTo
+type zoneObservance struct {
+	start      time.Time
+	offsetFrom int
+	offsetTo   int
+	rule       map[string]string
+	dates      []time.Time
+}
+
+// ParseCalendar reads the events out of an iCalendar object
+func ParseCalendar(data []byte) ([]models.CalendarEvent, error) {
+	// Unfold lines which were split to fit the line length limit
+	text := strings.Replace(string(data), "\r\n", "\n", -1)
+	text = strings.Replace(text, "\n ", "", -1)
+	text = strings.Replace(text, "\n\t", "", -1)
+
+	var method string
+	var eventProps [][]calendarProperty
+	zones := make(map[string]*calendarZone)
+	var zone *calendarZone
+	var observance *zoneObservance
+	components := make([]string, 0, 4)
+
+	for _, line := range strings.Split(text, "\n") {
+		prop, ok := parseCalendarLine(line)
+		if !ok {
+			continue
+		}
+
+		if prop.name == "BEGIN" {
+			component := strings.ToUpper(prop.value)
+			components = append(components, component)
+			switch component {
+			case "VEVENT":
+				eventProps = append(eventProps, make([]calendarProperty, 0, 20))
+			case "VTIMEZONE":
+				zone = &calendarZone{}
+			case "STANDARD", "DAYLIGHT":
+				if zone != nil {
+					observance = &zoneObservance{}
+					zone.observances = append(zone.observances, observance)
+				}
+			}
+			continue
+		}
+		if len(components) == 0 {
+			continue
+		}
+		component := components[len(components)-1]
+		if prop.name == "END" {
+			components = components[:len(components)-1]
+			switch component {
+			case "VTIMEZONE":
+				if zone != nil && len(zone.name) > 0 &&
+					len(zone.observances) > 0 {
+					zones[zone.name] = zone
+				}
+				zone = nil
+			case "STANDARD", "DAYLIGHT":
+				observance = nil
+			}
+			continue
+		}
+
+		switch component {
+		case "VCALENDAR":
+			if prop.name == "METHOD" {
+				method = strings.ToUpper(prop.value)
+			}
+		case "VEVENT":
+			eventProps[len(eventProps)-1] = append(eventProps[len(eventProps)-1],
+				prop)
+		case "VTIMEZONE":
+			if prop.name == "TZID" && zone != nil {
+				zone.name = prop.value
+			}
+		case "STANDARD", "DAYLIGHT":
+			if observance != nil {
+				readObservanceProperty(observance, prop)
+			}
+		}
+	}
+
+	if len(eventProps) == 0 {
+		return nil, errors.New("No events found in calendar")
+	}
+
+	// Times are read last, since time zones may be defined after the events
+	events := make([]models.CalendarEvent, 0, len(eventProps))
+	for _, props := range eventProps {
+		event := models.CalendarEvent{Method: method}
+		var duration time.Duration
+		hasEnd := false
+		for _, prop := range props {
+			switch prop.name {
+			case "DTSTART":
+				event.Start, event.StartTimeZone, event.AllDay =
+					parseCalendarTime(prop, zones)
+			case "DTEND":
+				event.End, event.EndTimeZone, _ = parseCalendarTime(prop, zones)
+				hasEnd = true
+			case "DURATION":
+				duration = parseCalendarDuration(prop.value)
+			default:
+				readCalendarProperty(&event, prop)
+			}
+		}
+		if !hasEnd {
+			if duration == 0 && event.AllDay {
+				duration = 24 * time.Hour
+			}
+			event.End = event.Start.Add(duration)
+			event.EndTimeZone = event.StartTimeZone
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func readCalendarProperty(event *models.CalendarEvent, prop calendarProperty) {
+	switch prop.name {
+	case "UID":
+		event.UID = prop.value
+	case "SEQUENCE":
+		event.Sequence, _ = strconv.Atoi(prop.value)
+	case "STATUS":
+		event.Status = strings.ToUpper(prop.value)
+	case "SUMMARY":
+		event.Summary = unescapeCalendarText(prop.value)
+	case "DESCRIPTION":
+		event.Description = unescapeCalendarText(prop.value)
+	case "LOCATION":
+		event.Location = unescapeCalendarText(prop.value)
+	case "ORGANIZER":
+		event.OrganizerName = prop.params["CN"]
+		event.OrganizerAddress = calendarAddress(prop.value)
+	case "ATTENDEE":
+		event.Attendees = append(event.Attendees, models.CalendarAttendee{
+			DisplayName:  prop.params["CN"],
+			EmailAddress: calendarAddress(prop.value),
+			Role:         strings.ToUpper(prop.params["ROLE"]),
+			Status:       strings.ToUpper(prop.params["PARTSTAT"]),
+			RSVP:         strings.EqualFold(prop.params["RSVP"], "TRUE"),
+		})
+	}
+}
+
+// parseCalendarLine splits an unfolded content line into its name,
+// parameters and value
+func parseCalendarLine(line string) (calendarProperty, bool) {
+	prop := calendarProperty{params: make(map[string]string)}
+	line = strings.TrimRight(line, "\r")
+
+	// The name ends at the first ';' or ':'
+	end := strings.IndexAny(line, ";:")
+	if end < 1 {
+		return prop, false
+	}
+	prop.name = strings.ToUpper(line[:end])
+
+	pos := end
+	for pos < len(line) && line[pos] == ';' {
+		pos++
+		eq := strings.IndexByte(line[pos:], '=')
+		if eq < 0 {
+			return prop, false
+		}
+		key := strings.ToUpper(line[pos : pos+eq])
+		pos += eq + 1
+
+		// Values may be quoted to hold ';', ':' or ','
+		var value string
+		if pos < len(line) && line[pos] == '"' {
+			close := strings.IndexByte(line[pos+1:], '"')
+			if close < 0 {
+				return prop, false
+			}
+			value = line[pos+1 : pos+1+close]
+			pos += close + 2
+		} else {
+			next := strings.IndexAny(line[pos:], ";:")
+			if next < 0 {
+				return prop, false
+			}
+			value = line[pos : pos+next]
+			pos += next
+		}
+		prop.params[key] = value
+	}
+
+	if pos >= len(line) || line[pos] != ':' {
+		return prop, false
+	}
+	prop.value = line[pos+1:]
+	return prop, true
+}
+
+// readObservanceProperty reads a property of a STANDARD or DAYLIGHT part of
+// a VTIMEZONE
+func readObservanceProperty(observance *zoneObservance,
+	prop calendarProperty) {
+
+	switch prop.name {
+	case "DTSTART":
+		observance.start, _ = time.Parse("20060102T150405",
+			strings.TrimSpace(prop.value))
+	case "TZOFFSETFROM":
+		observance.offsetFrom, _ = parseUTCOffset(prop.value)
+	case "TZOFFSETTO":
+		observance.offsetTo, _ = parseUTCOffset(prop.value)
+	case "RRULE":
+		observance.rule = make(map[string]string)
+		for _, part := range strings.Split(prop.value, ";") {
+			if idx := strings.Index(part, "="); idx > 0 {
+				observance.rule[strings.ToUpper(part[:idx])] =
+					strings.ToUpper(part[idx+1:])
+			}
+		}
+	case "RDATE":
+		for _, value := range strings.Split(prop.value, ",") {
+			date, err := time.Parse("20060102T150405", strings.TrimSpace(value))
+			if err == nil {
+				observance.dates = append(observance.dates, date)
+			}
+		}
+	}
+}
+
+// location gets a fixed zone with the offset in effect at a wall clock time,
+// given in UTC. This is the offset of the observance with the latest onset
+// at or before that time
+func (z *calendarZone) location(wall time.Time) *time.Location {
+	var latest time.Time
+	var first *zoneObservance
+	offset, found := 0, false
+	for _, observance := range z.observances {
+		if onset, ok := observance.lastOnset(wall); ok &&
+			(!found || onset.After(latest)) {
+			latest, offset, found = onset, observance.offsetTo, true
+		}
+		if first == nil || observance.start.Before(first.start) {
+			first = observance
+		}
+	}
+	if !found {
+		// Before any onset, the zone is on the offset the first one leaves
+		offset = first.offsetFrom
+	}
+	return time.FixedZone(z.name, offset)
+}
+
+// lastOnset finds the latest onset of an observance at or before a wall
+// clock time
+func (o *zoneObservance) lastOnset(wall time.Time) (time.Time, bool) {
+	var last time.Time
+	found := false
+	consider := func(onset time.Time) {
+		if !onset.After(wall) && !onset.Before(o.start) &&
+			(!found || onset.After(last)) {
+			last, found = onset, true
+		}
+	}
+
+	consider(o.start)
+	for _, date := range o.dates {
+		consider(date)
+	}
+	if o.rule["FREQ"] == "YEARLY" {
+		until, _ := time.Parse("20060102T150405Z", o.rule["UNTIL"])
+		for year := wall.Year() - 1; year <= wall.Year(); year++ {
+			onset, ok := o.yearlyOnset(year)
+			if ok && (until.IsZero() || !onset.After(until)) {
+				consider(onset)
+			}
+		}
+	}
+	return last, found
+}
+
+// yearlyOnset finds the onset of a yearly rule in the given year, e.g. the
+// last Sunday in March for "BYMONTH=3;BYDAY=-1SU"
+func (o *zoneObservance) yearlyOnset(year int) (time.Time, bool) {
+	month := o.start.Month()
+	if m, err := strconv.Atoi(o.rule["BYMONTH"]); err == nil {
+		month = time.Month(m)
+	}
+	hour, min, sec := o.start.Clock()
+	date := func(day int) time.Time {
+		return time.Date(year, month, day, hour, min, sec, 0, time.UTC)
+	}
+
+	var monthDays []int
+	for _, value := range strings.Split(o.rule["BYMONTHDAY"], ",") {
+		if day, err := strconv.Atoi(value); err == nil {
+			monthDays = append(monthDays, day)
+		}
+	}
+
+	byDay := o.rule["BYDAY"]
+	if len(byDay) < 2 {
+		day := o.start.Day()
+		if len(monthDays) > 0 {
+			day = monthDays[0]
+		}
+		onset := date(day)
+		return onset, onset.Month() == month
+	}
+	weekday, ok := weekdays[byDay[len(byDay)-2:]]
+	if !ok {
+		return time.Time{}, false
+	}
+	n := 1
+	if len(byDay) > 2 {
+		var err error
+		if n, err = strconv.Atoi(byDay[:len(byDay)-2]); err != nil || n == 0 {
+			return time.Time{}, false
+		}
+	} else if len(monthDays) > 0 {
+		// e.g. the first Sunday on or after the 8th, given as the days it
+		// may fall on
+		for _, day := range monthDays {
+			if onset := date(day); onset.Weekday() == weekday {
+				return onset, onset.Month() == month
+			}
+		}
+		return time.Time{}, false
+	}
+
+	var onset time.Time
+	if n > 0 {
+		first := date(1)
+		onset = date(1 + int(weekday-first.Weekday()+7)%7 + (n-1)*7)
+	} else {
+		last := date(1).AddDate(0, 1, -1)
+		onset = date(last.Day() - int(last.Weekday()-weekday+7)%7 + (n+1)*7)
+	}
+	return onset, onset.Month() == month
+}
+
+// parseCalendarTime reads a DATE or DATE-TIME value. Returns the time, the
+// name of its time zone, and whether it's a date without a time
+func parseCalendarTime(prop calendarProperty,
+	zones map[string]*calendarZone) (time.Time, string, bool) {
+
+	value := strings.TrimSpace(prop.value)
+	if strings.EqualFold(prop.params["VALUE"], "DATE") || len(value) == 8 {
+		t, err := time.Parse("20060102", value)
+		if err != nil {
+			return time.Time{}, "", true
+		}
+		return t, "", true
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		if err != nil {
+			return time.Time{}, "", false
+		}
+		return t, "UTC", false
+	}
+
+	wall, err := time.Parse("20060102T150405", value)
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	location := time.UTC
+	zone := ""
+	if tzid := prop.params["TZID"]; len(tzid) > 0 {
+		location, zone = calendarLocation(tzid, zones, wall)
+	}
+	year, month, day := wall.Date()
+	hour, min, sec := wall.Clock()
+	return time.Date(year, month, day, hour, min, sec, 0, location), zone, false
+}
+
+// calendarLocation finds the location for a TZID at a wall clock time,
+// trying IANA names, the names Outlook uses, and then the VTIMEZONE
+// definitions in the calendar. The name is empty if the location can't be
+// looked up again later
+func calendarLocation(tzid string, zones map[string]*calendarZone,
+	wall time.Time) (*time.Location, string) {
+
+	name := strings.TrimPrefix(strings.Trim(tzid, "\""), "/")
+	if location, err := time.LoadLocation(name); err == nil {
+		return location, location.String()
+	}
+	if iana, ok := windowsTimeZones[name]; ok {
+		if location, err := time.LoadLocation(iana); err == nil {
+			return location, iana
+		}
+	}
+	if zone, ok := zones[tzid]; ok {
+		return zone.location(wall), ""
+	}
+	return time.UTC, ""
+}
+
+// parseUTCOffset reads an offset such as "-0500" into seconds east of UTC
+func parseUTCOffset(value string) (int, bool) {
+	value = strings.TrimSpace(value)
+	if len(value) < 5 || (value[0] != '+' && value[0] != '-') {
+		return 0, false
+	}
+	hours, err := strconv.Atoi(value[1:3])
+	if err != nil {
+		return 0, false
+	}
+	minutes, err := strconv.Atoi(value[3:5])
+	if err != nil {
+		return 0, false
+	}
+	offset := hours*3600 + minutes*60
+	if value[0] == '-' {
+		offset = -offset
+	}
+	return offset, true
+}
+
+// parseCalendarDuration reads a duration such as "P1DT2H30M"
+func parseCalendarDuration(value string) time.Duration {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	negative := strings.HasPrefix(value, "-")
+	value = strings.TrimLeft(value, "+-")
+	if !strings.HasPrefix(value, "P") {
+		return 0
+	}
+
+	var duration time.Duration
+	number := 0
+	for _, c := range value[1:] {
+		switch {
+		case c >= '0' && c <= '9':
+			number = number*10 + int(c-'0')
+			continue
+		case c == 'W':
+			duration += time.Duration(number) * 7 * 24 * time.Hour
+		case c == 'D':
+			duration += time.Duration(number) * 24 * time.Hour
+		case c == 'H':
+			duration += time.Duration(number) * time.Hour
+		case c == 'M':
+			duration += time.Duration(number) * time.Minute
+		case c == 'S':
+			duration += time.Duration(number) * time.Second
+		}
+		number = 0
+	}
+	if negative {
+		return -duration
+	}
+	return duration
+}
+
+// calendarAddress gets the email address out of a mailto: URI
+func calendarAddress(value string) string {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(strings.ToLower(value), "mailto:") {
+		value = value[len("mailto:"):]
+	}
+	return value
+}
+
+func unescapeCalendarText(value string) string {
+	var buff bytes.Buffer
+	escaped := false
+	for _, c := range value {
+		if !escaped {
+			if c == '\\' {
+				escaped = true
+			} else {
+				buff.WriteRune(c)
+			}
+			continue
+		}
+		escaped = false
+		if c == 'n' || c == 'N' {
+			buff.WriteByte('\n')
+		} else {
+			buff.WriteRune(c)
+		}
+	}
+	return buff.String()
+}
+
+func escapeCalendarText(value string) string {
+	value = strings.Replace(value, "\\", "\\\\", -1)
+	value = strings.Replace(value, ";", "\\;", -1)
+	value = strings.Replace(value, ",", "\\,", -1)
+	value = strings.Replace(value, "\r\n", "\\n", -1)
+	return strings.Replace(value, "\n", "\\n", -1)
+}
+
+// quoteCalendarParam quotes a parameter value if it holds characters which
+// would otherwise end it
+func quoteCalendarParam(value string) string {
+	value = strings.Replace(value, "\"", "'", -1)
+	if strings.ContainsAny(value, ";:,") {
+		return "\"" + value + "\""
+	}
+	return value
+}
+
+// writeCalendarLine writes a content line, folding it so no line is longer
+// than 75 octets
+func writeCalendarLine(buff *bytes.Buffer, line string) {
+	limit := 75
+	for len(line) > limit {
+		// Don't split a multi-byte character across lines
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		buff.WriteString(line[:cut])
+		buff.WriteString("\r\n ")
+		line = line[cut:]
+		limit = 74
+	}
+	buff.WriteString(line)
+	buff.WriteString("\r\n")
+}
+
+func formatCalendarTime(t time.Time, allDay bool) string {
+	if allDay {
+		return ";VALUE=DATE:" + t.Format("20060102")
+	}
+	return ":" + t.UTC().Format("20060102T150405Z")
+}
+
+// BuildCalendarReply generates an iCalendar REPLY to an invite, giving the
+// attendee's response. response is one of the models.CalendarResponse values
+func BuildCalendarReply(event *models.CalendarEvent,
+	attendee models.CalendarAttendee, response string, now time.Time) []byte {
+
+	var buff bytes.Buffer
+	writeCalendarLine(&buff, "BEGIN:VCALENDAR")
+	writeCalendarLine(&buff, "PRODID:"+calendarProductID)
+	writeCalendarLine(&buff, "VERSION:2.0")
+	writeCalendarLine(&buff, "METHOD:REPLY")
+	writeCalendarLine(&buff, "BEGIN:VEVENT")
+	writeCalendarLine(&buff, "UID:"+event.UID)
+	writeCalendarLine(&buff, "SEQUENCE:"+strconv.Itoa(event.Sequence))
+	writeCalendarLine(&buff, "DTSTAMP:"+now.UTC().Format("20060102T150405Z"))
+	writeCalendarLine(&buff, "DTSTART"+formatCalendarTime(event.Start,
+		event.AllDay))
+	writeCalendarLine(&buff, "DTEND"+formatCalendarTime(event.End,
+		event.AllDay))
+	if len(event.Summary) > 0 {
+		writeCalendarLine(&buff, "SUMMARY:"+escapeCalendarText(event.Summary))
+	}
+
+	organizer := "ORGANIZER"
+	if len(event.OrganizerName) > 0 {
+		organizer += ";CN=" + quoteCalendarParam(event.OrganizerName)
+	}
+	writeCalendarLine(&buff, organizer+":mailto:"+event.OrganizerAddress)
+
+	line := "ATTENDEE;PARTSTAT=" + response
+	if len(attendee.DisplayName) > 0 {
+		line += ";CN=" + quoteCalendarParam(attendee.DisplayName)
+	}
+	writeCalendarLine(&buff, line+":mailto:"+attendee.EmailAddress)
+
+	writeCalendarLine(&buff, "END:VEVENT")
+	writeCalendarLine(&buff, "END:VCALENDAR")
+	return buff.Bytes()
+}
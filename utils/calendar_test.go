@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// calendarZones defines time zones which can't be looked up by name, with
+// US and EU daylight saving rules
+const calendarZones = "BEGIN:VTIMEZONE\r\n" +
+	"TZID:Custom Eastern\r\n" +
+	"BEGIN:STANDARD\r\n" +
+	"DTSTART:16010101T020000\r\n" +
+	"TZOFFSETFROM:-0400\r\n" +
+	"TZOFFSETTO:-0500\r\n" +
+	"RRULE:FREQ=YEARLY;BYDAY=1SU;BYMONTH=11\r\n" +
+	"END:STANDARD\r\n" +
+	"BEGIN:DAYLIGHT\r\n" +
+	"DTSTART:16010101T020000\r\n" +
+	"TZOFFSETFROM:-0500\r\n" +
+	"TZOFFSETTO:-0400\r\n" +
+	"RRULE:FREQ=YEARLY;BYDAY=2SU;BYMONTH=3\r\n" +
+	"END:DAYLIGHT\r\n" +
+	"END:VTIMEZONE\r\n" +
+	"BEGIN:VTIMEZONE\r\n" +
+	"TZID:Custom Central Europe\r\n" +
+	"BEGIN:DAYLIGHT\r\n" +
+	"DTSTART:19810329T020000\r\n" +
+	"TZOFFSETFROM:+0100\r\n" +
+	"TZOFFSETTO:+0200\r\n" +
+	"RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU\r\n" +
+	"END:DAYLIGHT\r\n" +
+	"BEGIN:STANDARD\r\n" +
+	"DTSTART:19961027T030000\r\n" +
+	"TZOFFSETFROM:+0200\r\n" +
+	"TZOFFSETTO:+0100\r\n" +
+	"RRULE:FREQ=YEARLY;BYMONTH=10;BYDAY=-1SU\r\n" +
+	"END:STANDARD\r\n" +
+	"END:VTIMEZONE\r\n"
+
+func TestParseCalendar(t *testing.T) {
+	utc := func(value string) time.Time {
+		parsed, err := time.Parse("2006-01-02 15:04", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		name    string
+		event   string
+		summary string
+		start   time.Time
+		end     time.Time
+		zone    string
+		allDay  bool
+	}{
+		{"UTC", "SUMMARY:Standup\r\n" +
+			"DTSTART:20260310T150000Z\r\n" +
+			"DTEND:20260310T151500Z\r\n",
+			"Standup", utc("2026-03-10 15:00"), utc("2026-03-10 15:15"), "UTC",
+			false},
+		{"duration", "SUMMARY:Standup\r\n" +
+			"DTSTART:20260310T150000Z\r\n" +
+			"DURATION:PT1H30M\r\n",
+			"Standup", utc("2026-03-10 15:00"), utc("2026-03-10 16:30"), "UTC",
+			false},
+		{"floating time", "SUMMARY:Lunch\r\n" +
+			"DTSTART:20260310T120000\r\n" +
+			"DTEND:20260310T130000\r\n",
+			"Lunch", utc("2026-03-10 12:00"), utc("2026-03-10 13:00"), "",
+			false},
+		{"VTIMEZONE, standard time", "SUMMARY:Review\r\n" +
+			"DTSTART;TZID=Custom Eastern:20260110T100000\r\n" +
+			"DTEND;TZID=Custom Eastern:20260110T110000\r\n",
+			"Review", utc("2026-01-10 15:00"), utc("2026-01-10 16:00"), "",
+			false},
+		{"VTIMEZONE, daylight time", "SUMMARY:Review\r\n" +
+			"DTSTART;TZID=Custom Eastern:20260710T100000\r\n" +
+			"DTEND;TZID=Custom Eastern:20260710T110000\r\n",
+			"Review", utc("2026-07-10 14:00"), utc("2026-07-10 15:00"), "",
+			false},
+		{"VTIMEZONE, day before daylight time",
+			"DTSTART;TZID=Custom Eastern:20260307T090000\r\n",
+			"", utc("2026-03-07 14:00"), utc("2026-03-07 14:00"), "", false},
+		{"VTIMEZONE, day daylight time starts",
+			"DTSTART;TZID=Custom Eastern:20260308T090000\r\n",
+			"", utc("2026-03-08 13:00"), utc("2026-03-08 13:00"), "", false},
+		{"VTIMEZONE, last Sunday rule",
+			"DTSTART;TZID=Custom Central Europe:20261024T090000\r\n" +
+				"DTEND;TZID=Custom Central Europe:20261025T090000\r\n",
+			"", utc("2026-10-24 07:00"), utc("2026-10-25 08:00"), "", false},
+		{"IANA time zone", "DTSTART;TZID=America/New_York:20260710T100000\r\n",
+			"", utc("2026-07-10 14:00"), utc("2026-07-10 14:00"),
+			"America/New_York", false},
+		{"all day", "SUMMARY:Holiday\r\n" +
+			"DTSTART;VALUE=DATE:20260704\r\n",
+			"Holiday", utc("2026-07-04 00:00"), utc("2026-07-05 00:00"), "",
+			true},
+		{"all day, several days", "SUMMARY:Offsite\r\n" +
+			"DTSTART;VALUE=DATE:20260706\r\n" +
+			"DTEND;VALUE=DATE:20260709\r\n",
+			"Offsite", utc("2026-07-06 00:00"), utc("2026-07-09 00:00"), "",
+			true},
+		{"folded lines", "SUMMARY:Quarterly plan\r\n" +
+			" ning meeting\r\n" +
+			"DTSTART:2026031\r\n" +
+			"\t0T150000Z\r\n",
+			"Quarterly planning meeting", utc("2026-03-10 15:00"),
+			utc("2026-03-10 15:00"), "UTC", false},
+		{"folded lines with bare line feeds", "SUMMARY:Quarterly\n" +
+			"  review\n" +
+			"DTSTART:20260310T150000Z\n",
+			"Quarterly review", utc("2026-03-10 15:00"),
+			utc("2026-03-10 15:00"), "UTC", false},
+	}
+
+	for _, test := range tests {
+		events, err := ParseCalendar([]byte("BEGIN:VCALENDAR\r\n" +
+			"METHOD:REQUEST\r\n" + calendarZones + "BEGIN:VEVENT\r\n" +
+			"UID:event@example.com\r\n" + test.event + "END:VEVENT\r\n" +
+			"END:VCALENDAR\r\n"))
+		if err != nil {
+			t.Errorf("%s: failed to parse: %s", test.name, err.Error())
+			continue
+		}
+		if len(events) != 1 {
+			t.Errorf("%s: %d events, expected 1", test.name, len(events))
+			continue
+		}
+		event := events[0]
+		if event.Summary != test.summary {
+			t.Errorf("%s: summary is %q, expected %q", test.name, event.Summary,
+				test.summary)
+		}
+		if !event.Start.Equal(test.start) || !event.End.Equal(test.end) {
+			t.Errorf("%s: event is from %s to %s, expected %s to %s", test.name,
+				event.Start.UTC(), event.End.UTC(), test.start, test.end)
+		}
+		if event.StartTimeZone != test.zone {
+			t.Errorf("%s: time zone is %q, expected %q", test.name,
+				event.StartTimeZone, test.zone)
+		}
+		if event.AllDay != test.allDay {
+			t.Errorf("%s: all day is %t, expected %t", test.name, event.AllDay,
+				test.allDay)
+		}
+		if event.Method != "REQUEST" ||
+			!strings.EqualFold(event.UID, "event@example.com") {
+			t.Errorf("%s: method %q and UID %q not read", test.name,
+				event.Method, event.UID)
+		}
+	}
+}
+
+func TestParseCalendarWithoutEvents(t *testing.T) {
+	_, err := ParseCalendar([]byte("BEGIN:VCALENDAR\r\n" + calendarZones +
+		"END:VCALENDAR\r\n"))
+	if err == nil {
+		t.Error("Calendar without events parsed without error")
+	}
+}
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+)
+
+// charsetReader converts text in the named charset to UTF-8
+func charsetReader(label string, input io.Reader) (io.Reader, error) {
+	encoding, _ := charset.Lookup(label)
+	if encoding == nil {
+		return nil, errors.New("Unknown charset " + label)
+	}
+	return encoding.NewDecoder().Reader(input), nil
+}
+
+// contentCharset gets the charset declared on a part's Content-Type header
+func contentCharset(header headerInterface) string {
+	_, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(params["charset"]))
+}
+
+// decodeToUTF8 converts text to UTF-8 from its declared charset. If no
+// charset was declared, or the declared one is unknown, text which isn't
+// already valid UTF-8 has its charset detected. Returns the converted text
+// and the name of the charset it was converted from
+func decodeToUTF8(data []byte, declared string,
+	contentType string) ([]byte, string) {
+
+	if len(declared) > 0 {
+		if encoding, name := charset.Lookup(declared); encoding != nil {
+			converted, err := encoding.NewDecoder().Bytes(data)
+			if err == nil {
+				return converted, name
+			}
+		}
+	}
+
+	if utf8.Valid(data) {
+		return data, "utf-8"
+	}
+
+	encoding, name, _ := charset.DetermineEncoding(data, contentType)
+	converted, err := encoding.NewDecoder().Bytes(data)
+	if err != nil {
+		return data, ""
+	}
+	return converted, name
+}
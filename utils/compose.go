@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/anishmgoyal/calagora-admin/models"
+)
+
+// OutboundPart is one body part of a composed email
+type OutboundPart struct {
+	ContentType string
+	Body        []byte
+}
+
+// ComposeEmail builds a raw message ready to be sent. Multiple parts are sent
+// as alternatives, in order of increasing preference
+func ComposeEmail(from models.Recipient, to []models.Recipient,
+	subject string, inReplyTo string, parts []OutboundPart) []byte {
+
+	var buff bytes.Buffer
+	writeHeader := func(key string, value string) {
+		buff.WriteString(key + ": " + value + "\r\n")
+	}
+
+	addresses := make([]string, 0, len(to))
+	for _, recipient := range to {
+		addresses = append(addresses, formatAddress(recipient))
+	}
+
+	writeHeader("From", formatAddress(from))
+	writeHeader("To", strings.Join(addresses, ", "))
+	writeHeader("Subject", mime.QEncoding.Encode("utf-8", subject))
+	writeHeader("Date", time.Now().Format(time.RFC1123Z))
+	writeHeader("Message-Id", newMessageID(from.EmailAddress))
+	if len(inReplyTo) > 0 {
+		writeHeader("In-Reply-To", inReplyTo)
+		writeHeader("References", inReplyTo)
+	}
+	writeHeader("MIME-Version", "1.0")
+
+	if len(parts) == 1 {
+		writeHeader("Content-Type", parts[0].ContentType)
+		writeHeader("Content-Transfer-Encoding", "quoted-printable")
+		buff.WriteString("\r\n")
+		writeQuotedPrintable(&buff, parts[0].Body)
+		return buff.Bytes()
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range parts {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Type", part.ContentType)
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		w, err := mw.CreatePart(header)
+		if err != nil {
+			continue
+		}
+		writeQuotedPrintable(w, part.Body)
+	}
+	mw.Close()
+
+	writeHeader("Content-Type", "multipart/alternative; boundary=\""+
+		mw.Boundary()+"\"")
+	buff.WriteString("\r\n")
+	buff.Write(body.Bytes())
+	return buff.Bytes()
+}
+
+func formatAddress(recipient models.Recipient) string {
+	address := mail.Address{
+		Name:    recipient.DisplayName,
+		Address: recipient.EmailAddress,
+	}
+	return address.String()
+}
+
+func writeQuotedPrintable(w io.Writer, body []byte) {
+	qp := quotedprintable.NewWriter(w)
+	qp.Write(body)
+	qp.Close()
+}
+
+// newMessageID generates a unique Message-ID for mail sent from an address
+func newMessageID(from string) string {
+	domain := "localhost"
+	if idx := strings.LastIndex(from, "@"); idx > -1 {
+		domain = from[idx+1:]
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return "<" + hex.EncodeToString(id) + "@" + domain + ">"
+}
@@ -0,0 +1,194 @@
+package utils
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var monthNames = map[string]time.Month{
+	"jan": time.January, "feb": time.February, "mar": time.March,
+	"apr": time.April, "may": time.May, "jun": time.June,
+	"jul": time.July, "aug": time.August, "sep": time.September,
+	"oct": time.October, "nov": time.November, "dec": time.December,
+}
+
+// obsoleteZones are the zone names allowed by RFC 822, with their offsets
+// in hours
+var obsoleteZones = map[string]int{
+	"UT": 0, "UTC": 0, "GMT": 0, "Z": 0,
+	"EST": -5, "EDT": -4, "CST": -6, "CDT": -5,
+	"MST": -7, "MDT": -6, "PST": -8, "PDT": -7,
+}
+
+// ParseDate reads the value of a Date header. Besides RFC 5322 dates, it
+// accepts the obsolete forms of RFC 822, such as two digit years and named
+// time zones, as well as comments, a missing day of the week or seconds, and
+// other common deviations
+func ParseDate(value string) (time.Time, error) {
+	tokens := dateTokens(stripComments(value))
+	if len(tokens) > 0 {
+		// The day of the week is optional, and not checked
+		if _, err := strconv.Atoi(tokens[0]); err != nil {
+			if _, ok := lookupMonth(tokens[0]); !ok {
+				tokens = tokens[1:]
+			}
+		}
+	}
+	if len(tokens) < 4 {
+		return time.Time{}, errors.New("Malformed date: " + value)
+	}
+
+	// Usually "2 Jan 2006", but some clients send "Jan 2 2006"
+	dayToken, monthToken := tokens[0], tokens[1]
+	if _, ok := lookupMonth(dayToken); ok {
+		dayToken, monthToken = monthToken, dayToken
+	}
+	day, err := strconv.Atoi(dayToken)
+	if err != nil || day < 1 || day > 31 {
+		return time.Time{}, errors.New("Malformed day in date: " + value)
+	}
+	month, ok := lookupMonth(monthToken)
+	if !ok {
+		return time.Time{}, errors.New("Malformed month in date: " + value)
+	}
+
+	// The year and time may also be swapped, as in asctime() dates
+	yearToken, timeToken := tokens[2], tokens[3]
+	if strings.Contains(yearToken, ":") {
+		yearToken, timeToken = timeToken, yearToken
+	}
+	year, err := parseDateYear(yearToken)
+	if err != nil {
+		return time.Time{}, errors.New("Malformed year in date: " + value)
+	}
+
+	hour, minute, second, err := parseDateTime(timeToken)
+	if err != nil {
+		return time.Time{}, errors.New("Malformed time in date: " + value)
+	}
+
+	location := time.UTC
+	if len(tokens) > 4 {
+		location = parseDateZone(tokens[4])
+	}
+
+	t := time.Date(year, month, day, hour, minute, second, 0, location)
+	if t.Day() != day {
+		return time.Time{}, errors.New("Day out of range in date: " + value)
+	}
+	return t, nil
+}
+
+// stripComments removes parenthesized comments, which may be nested
+func stripComments(value string) string {
+	var result []rune
+	depth := 0
+	escaped := false
+	for _, c := range value {
+		switch {
+		case escaped:
+			escaped = false
+			if depth == 0 {
+				result = append(result, c)
+			}
+		case c == '\\':
+			escaped = true
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+			result = append(result, ' ')
+		case depth == 0:
+			result = append(result, c)
+		}
+	}
+	return string(result)
+}
+
+// dateTokens splits a date on whitespace and commas. A time zone offset
+// written without a space before it is split from the time
+func dateTokens(value string) []string {
+	fields := strings.FieldsFunc(value, func(c rune) bool {
+		return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == ','
+	})
+
+	tokens := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if idx := strings.IndexAny(field, "+-"); idx > 0 &&
+			strings.Contains(field[:idx], ":") {
+			tokens = append(tokens, field[:idx], field[idx:])
+			continue
+		}
+		tokens = append(tokens, field)
+	}
+	return tokens
+}
+
+func lookupMonth(token string) (time.Month, bool) {
+	if len(token) < 3 {
+		return 0, false
+	}
+	month, ok := monthNames[strings.ToLower(token[:3])]
+	return month, ok
+}
+
+// parseDateYear reads a year, mapping two and three digit years as described
+// in RFC 5322 section 4.3
+func parseDateYear(token string) (int, error) {
+	year, err := strconv.Atoi(strings.TrimSuffix(token, "."))
+	if err != nil || year < 0 {
+		return 0, errors.New("Malformed year")
+	}
+	switch {
+	case len(token) <= 2 && year < 50:
+		year += 2000
+	case len(token) <= 3 && year < 1000:
+		year += 1900
+	}
+	return year, nil
+}
+
+// parseDateTime reads a time of day, where the seconds are optional. Some
+// clients use '.' instead of ':'
+func parseDateTime(token string) (int, int, int, error) {
+	parts := strings.FieldsFunc(token, func(c rune) bool {
+		return c == ':' || c == '.'
+	})
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, 0, 0, errors.New("Malformed time")
+	}
+
+	values := []int{0, 0, 0}
+	for i, part := range parts {
+		value, err := strconv.Atoi(part)
+		if err != nil {
+			return 0, 0, 0, errors.New("Malformed time")
+		}
+		values[i] = value
+	}
+	// Allow a leap second, which time.Date will roll over
+	if values[0] > 23 || values[1] > 59 || values[2] > 60 {
+		return 0, 0, 0, errors.New("Time out of range")
+	}
+	return values[0], values[1], values[2], nil
+}
+
+// parseDateZone reads a numeric or named time zone. Zones which can't be
+// understood, such as military zones, are treated as UTC as RFC 5322 advises
+func parseDateZone(token string) *time.Location {
+	if offset, ok := parseUTCOffset(token); ok {
+		if offset == 0 {
+			return time.UTC
+		}
+		return time.FixedZone("", offset)
+	}
+	if hours, ok := obsoleteZones[strings.ToUpper(token)]; ok {
+		if hours == 0 {
+			return time.UTC
+		}
+		return time.FixedZone(strings.ToUpper(token), hours*3600)
+	}
+	return time.UTC
+}
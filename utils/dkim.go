@@ -0,0 +1,500 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	_ "crypto/sha256" // registers the hash DKIM signs with
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anishmgoyal/calagora-admin/models"
+)
+
+// maxDKIMSignatures is how many DKIM-Signature headers are checked. Each may
+// need a DNS lookup, so a message with many can't hold up delivery for long
+const maxDKIMSignatures = 5
+
+// DNSResolver looks up the TXT records DKIM public keys are published in
+type DNSResolver interface {
+	LookupTXT(name string) ([]string, error)
+}
+
+// NetResolver looks up records using the system's DNS resolver
+type NetResolver struct {
+	Timeout time.Duration
+}
+
+// LookupTXT finds the TXT records for a name
+func (r NetResolver) LookupTXT(name string) ([]string, error) {
+	ctx := context.Background()
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+	return net.DefaultResolver.LookupTXT(ctx, name)
+}
+
+// StaticResolver answers lookups from a fixed map of names to TXT records,
+// e.g. for tests
+type StaticResolver map[string][]string
+
+// LookupTXT finds the TXT records for a name
+func (r StaticResolver) LookupTXT(name string) ([]string, error) {
+	records, ok := r[strings.ToLower(strings.TrimSuffix(name, "."))]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name,
+			IsNotFound: true}
+	}
+	return records, nil
+}
+
+// DKIMResult is the outcome of checking one DKIM-Signature header
+type DKIMResult struct {
+	Domain   string
+	Selector string
+	// Result is one of the AuthResult values
+	Result string
+	Reason string
+}
+
+// dkimSignature holds the tags of a DKIM-Signature header
+type dkimSignature struct {
+	tags          map[string]string
+	header        string
+	headers       []string
+	headerCanon   string
+	bodyCanon     string
+	algorithm     string
+	hash          crypto.Hash
+	domain        string
+	selector      string
+	bodyLength    int64
+	hasBodyLength bool
+}
+
+// headerField is a raw header field, exactly as it appears in the message
+type headerField struct {
+	name string
+	raw  string
+}
+
+// VerifyDKIM checks the DKIM-Signature headers of a raw message, up to
+// maxDKIMSignatures from the top, looking up public keys through the resolver
+func VerifyDKIM(raw []byte, resolver DNSResolver) []DKIMResult {
+	fields, body := splitRawMessage(raw)
+	results := make([]DKIMResult, 0, 2)
+	for _, field := range fields {
+		if !strings.EqualFold(field.name, "DKIM-Signature") {
+			continue
+		}
+		if len(results) == maxDKIMSignatures {
+			break
+		}
+		results = append(results, verifyDKIMSignature(field, fields, body,
+			resolver))
+	}
+	return results
+}
+
+// VerifyEmailDKIM checks the DKIM signatures of an email's raw source,
+// recording the best result on the email. It's meant for mail which arrives
+// without verdicts from a trusted server
+func VerifyEmailDKIM(email *models.Email, resolver DNSResolver) {
+	results := VerifyDKIM(email.RawSource, resolver)
+	if len(results) == 0 {
+		email.DKIMVerdict = models.AuthResultNone
+		email.DKIMDomain = ""
+	} else {
+		// Prefer a passing signature from the From domain
+		best := results[0]
+		from := addressDomain(email.From)
+		for _, result := range results {
+			if result.Result != models.AuthResultPass {
+				continue
+			}
+			if best.Result != models.AuthResultPass ||
+				domainsAligned(result.Domain, from) {
+				best = result
+			}
+		}
+		email.DKIMVerdict = best.Result
+		email.DKIMDomain = best.Domain
+	}
+
+	email.IsUnauthenticated = email.ParentID == 0 &&
+		isOwnDomain(addressDomain(email.From)) && !isAligned(email)
+}
+
+func verifyDKIMSignature(field headerField, fields []headerField,
+	body []byte, resolver DNSResolver) DKIMResult {
+
+	sig, err := parseDKIMSignature(field)
+	result := DKIMResult{}
+	if sig != nil {
+		result.Domain = sig.domain
+		result.Selector = sig.selector
+	}
+	if err != nil {
+		result.Result = models.AuthResultPermError
+		result.Reason = err.Error()
+		return result
+	}
+
+	if x, ok := sig.tags["x"]; ok {
+		expires, err := strconv.ParseInt(x, 10, 64)
+		if err == nil && time.Now().Unix() > expires {
+			result.Result = models.AuthResultFail
+			result.Reason = "Signature has expired"
+			return result
+		}
+	}
+
+	// Check the body first, as it needs no lookup
+	canonical := canonicalizeBody(body, sig.bodyCanon)
+	if sig.hasBodyLength {
+		if sig.bodyLength > int64(len(canonical)) {
+			result.Result = models.AuthResultFail
+			result.Reason = "Body is shorter than the signed length"
+			return result
+		}
+		canonical = canonical[:sig.bodyLength]
+	}
+	bodyHasher := sig.hash.New()
+	bodyHasher.Write(canonical)
+	bodyHash, err := base64.StdEncoding.DecodeString(
+		stripWhitespace(sig.tags["bh"]))
+	if err != nil || !bytes.Equal(bodyHasher.Sum(nil), bodyHash) {
+		result.Result = models.AuthResultFail
+		result.Reason = "Body hash does not match"
+		return result
+	}
+
+	key, keyType, err := lookupDKIMKey(sig, resolver)
+	if err != nil {
+		result.Result = models.AuthResultPermError
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.Temporary() {
+			result.Result = models.AuthResultTempError
+		}
+		result.Reason = err.Error()
+		return result
+	}
+	if !strings.HasPrefix(sig.algorithm, keyType+"-") {
+		result.Result = models.AuthResultPermError
+		result.Reason = "Key type does not match the signing algorithm"
+		return result
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(
+		stripWhitespace(sig.tags["b"]))
+	if err != nil {
+		result.Result = models.AuthResultPermError
+		result.Reason = "Malformed signature"
+		return result
+	}
+
+	headerHasher := sig.hash.New()
+	writeSignedHeaders(headerHasher, sig, fields)
+	digest := headerHasher.Sum(nil)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(pub, sig.hash, digest, signature)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, digest, signature) {
+			err = errors.New("Signature does not match")
+		}
+	default:
+		err = errors.New("Unsupported key")
+	}
+	if err != nil {
+		result.Result = models.AuthResultFail
+		result.Reason = "Signature does not match"
+		return result
+	}
+
+	result.Result = models.AuthResultPass
+	return result
+}
+
+// splitRawMessage splits a raw message into its header fields and body,
+// with all line endings converted to CRLF
+func splitRawMessage(raw []byte) ([]headerField, []byte) {
+	text := strings.Replace(string(raw), "\r\n", "\n", -1)
+	text = strings.Replace(text, "\n", "\r\n", -1)
+
+	headerText := text
+	body := ""
+	if idx := strings.Index(text, "\r\n\r\n"); idx > -1 {
+		headerText = text[:idx+2]
+		body = text[idx+4:]
+	}
+
+	fields := make([]headerField, 0, 32)
+	for _, line := range strings.SplitAfter(headerText, "\r\n") {
+		if len(line) == 0 {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1].raw += line
+			continue
+		}
+		idx := strings.Index(line, ":")
+		if idx < 1 {
+			continue
+		}
+		fields = append(fields, headerField{
+			name: strings.TrimSpace(line[:idx]),
+			raw:  line,
+		})
+	}
+	return fields, []byte(body)
+}
+
+func parseDKIMSignature(field headerField) (*dkimSignature, error) {
+	value := field.raw[strings.Index(field.raw, ":")+1:]
+	sig := &dkimSignature{
+		tags:   parseTagList(value),
+		header: field.raw,
+	}
+	sig.domain = strings.ToLower(sig.tags["d"])
+	sig.selector = sig.tags["s"]
+
+	if sig.tags["v"] != "1" {
+		return sig, errors.New("Unsupported DKIM version")
+	}
+	for _, tag := range []string{"a", "b", "bh", "d", "h", "s"} {
+		if len(sig.tags[tag]) == 0 {
+			return sig, errors.New("Missing required tag " + tag)
+		}
+	}
+
+	sig.algorithm = strings.ToLower(sig.tags["a"])
+	switch sig.algorithm {
+	case "rsa-sha256", "ed25519-sha256":
+		sig.hash = crypto.SHA256
+	case "rsa-sha1":
+		// SHA-1 signatures can be forged, so RFC 8301 forbids accepting them
+		return sig, errors.New("Algorithm rsa-sha1 is no longer accepted")
+	default:
+		return sig, errors.New("Unsupported algorithm " + sig.algorithm)
+	}
+
+	sig.headerCanon, sig.bodyCanon = "simple", "simple"
+	if c, ok := sig.tags["c"]; ok {
+		parts := strings.SplitN(strings.ToLower(c), "/", 2)
+		sig.headerCanon = parts[0]
+		if len(parts) > 1 {
+			sig.bodyCanon = parts[1]
+		}
+	}
+	for _, canon := range []string{sig.headerCanon, sig.bodyCanon} {
+		if canon != "simple" && canon != "relaxed" {
+			return sig, errors.New("Unsupported canonicalization " + canon)
+		}
+	}
+
+	signsFrom := false
+	for _, name := range strings.Split(sig.tags["h"], ":") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		sig.headers = append(sig.headers, name)
+		if strings.EqualFold(name, "From") {
+			signsFrom = true
+		}
+	}
+	if !signsFrom {
+		return sig, errors.New("From header is not signed")
+	}
+
+	if i, ok := sig.tags["i"]; ok && !domainsAligned(addressDomain(i),
+		sig.domain) {
+		return sig, errors.New("Identity is not within the signing domain")
+	}
+
+	if l, ok := sig.tags["l"]; ok {
+		length, err := strconv.ParseInt(l, 10, 64)
+		if err != nil || length < 0 {
+			return sig, errors.New("Malformed body length")
+		}
+		sig.bodyLength, sig.hasBodyLength = length, true
+	}
+	return sig, nil
+}
+
+// parseTagList reads a list of tags such as "v=1; a=rsa-sha256"
+func parseTagList(value string) map[string]string {
+	tags := make(map[string]string)
+	for _, part := range strings.Split(value, ";") {
+		idx := strings.Index(part, "=")
+		if idx < 0 {
+			continue
+		}
+		name := strings.TrimSpace(part[:idx])
+		tags[name] = strings.TrimSpace(part[idx+1:])
+	}
+	return tags
+}
+
+// lookupDKIMKey finds the public key for a signature. Returns the key and
+// its type, either "rsa" or "ed25519"
+func lookupDKIMKey(sig *dkimSignature,
+	resolver DNSResolver) (crypto.PublicKey, string, error) {
+
+	records, err := resolver.LookupTXT(sig.selector + "._domainkey." +
+		sig.domain)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(records) == 0 {
+		return nil, "", errors.New("No key published")
+	}
+
+	tags := parseTagList(strings.Join(records, ""))
+	if v, ok := tags["v"]; ok && v != "DKIM1" {
+		return nil, "", errors.New("Unsupported key version")
+	}
+	data := stripWhitespace(tags["p"])
+	if len(data) == 0 {
+		return nil, "", errors.New("Key has been revoked")
+	}
+	der, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, "", errors.New("Malformed key")
+	}
+
+	keyType := strings.ToLower(tags["k"])
+	if len(keyType) == 0 {
+		keyType = "rsa"
+	}
+	switch keyType {
+	case "rsa":
+		if key, err := x509.ParsePKIXPublicKey(der); err == nil {
+			if rsaKey, ok := key.(*rsa.PublicKey); ok {
+				return rsaKey, keyType, nil
+			}
+		}
+		if key, err := x509.ParsePKCS1PublicKey(der); err == nil {
+			return key, keyType, nil
+		}
+		return nil, "", errors.New("Malformed key")
+	case "ed25519":
+		if len(der) != ed25519.PublicKeySize {
+			return nil, "", errors.New("Malformed key")
+		}
+		return ed25519.PublicKey(der), keyType, nil
+	}
+	return nil, "", errors.New("Unsupported key type " + keyType)
+}
+
+// writeSignedHeaders writes the canonical form of the signed headers, and
+// then of the signature itself with its signature removed. Repeated headers
+// are used from the bottom up
+func writeSignedHeaders(h hash.Hash, sig *dkimSignature,
+	fields []headerField) {
+
+	used := make(map[int]bool)
+	for _, name := range sig.headers {
+		for i := len(fields) - 1; i >= 0; i-- {
+			if used[i] || !strings.EqualFold(fields[i].name, name) {
+				continue
+			}
+			used[i] = true
+			h.Write([]byte(canonicalizeHeader(fields[i].raw, sig.headerCanon)))
+			break
+		}
+	}
+
+	header := canonicalizeHeader(removeSignatureValue(sig.header),
+		sig.headerCanon)
+	h.Write([]byte(strings.TrimSuffix(header, "\r\n")))
+}
+
+// removeSignatureValue empties the b= tag of a raw DKIM-Signature header,
+// leaving everything else as it was
+func removeSignatureValue(raw string) string {
+	colon := strings.Index(raw, ":") + 1
+	parts := strings.Split(raw[colon:], ";")
+	for i, part := range parts {
+		idx := strings.Index(part, "=")
+		if idx > -1 && strings.TrimSpace(part[:idx]) == "b" {
+			value := part[idx+1:]
+			// Keep a trailing line ending, if the tag ends the header
+			suffix := ""
+			if strings.HasSuffix(value, "\r\n") {
+				suffix = "\r\n"
+			}
+			parts[i] = part[:idx+1] + suffix
+		}
+	}
+	return raw[:colon] + strings.Join(parts, ";")
+}
+
+func canonicalizeHeader(raw string, canon string) string {
+	if canon == "simple" {
+		return raw
+	}
+
+	idx := strings.Index(raw, ":")
+	name := strings.ToLower(strings.TrimSpace(raw[:idx]))
+	value := strings.Replace(raw[idx+1:], "\r\n", "", -1)
+	value = collapseWhitespace(strings.Trim(value, " \t"))
+	return name + ":" + value + "\r\n"
+}
+
+func canonicalizeBody(body []byte, canon string) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	if canon == "relaxed" {
+		for i, line := range lines {
+			lines[i] = collapseWhitespace(strings.TrimRight(line, " \t"))
+		}
+	}
+
+	// Trailing empty lines are ignored
+	for len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		if canon == "simple" {
+			return []byte("\r\n")
+		}
+		return []byte{}
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// collapseWhitespace reduces each run of spaces and tabs to a single space
+func collapseWhitespace(value string) string {
+	var result strings.Builder
+	space := false
+	for _, c := range value {
+		if c == ' ' || c == '\t' {
+			space = true
+			continue
+		}
+		if space {
+			result.WriteByte(' ')
+			space = false
+		}
+		result.WriteRune(c)
+	}
+	if space {
+		result.WriteByte(' ')
+	}
+	return result.String()
+}
+
+func stripWhitespace(value string) string {
+	return strings.Join(strings.Fields(value), "")
+}
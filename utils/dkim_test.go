@@ -0,0 +1,258 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/anishmgoyal/calagora-admin/models"
+)
+
+// rfc8463Message is the example from RFC 8463, signed with both RSA and
+// Ed25519 using relaxed canonicalization
+const rfc8463Message = "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;\r\n" +
+	" d=football.example.com; i=@football.example.com;\r\n" +
+	" q=dns/txt; s=brisbane; t=1528637909; h=from : to :\r\n" +
+	" subject : date : message-id : from : subject : date;\r\n" +
+	" bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;\r\n" +
+	" b=/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11Bus\r\n" +
+	" Fa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw==\r\n" +
+	"DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/relaxed;\r\n" +
+	" d=football.example.com; i=@football.example.com;\r\n" +
+	" q=dns/txt; s=test; t=1528637909; h=from : to : subject :\r\n" +
+	" date : message-id : from : subject : date;\r\n" +
+	" bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;\r\n" +
+	" b=F45dVWDfMbQDGHJFlXUNB2HKfbCeLRyhDXgFpEL8GwpsRe0IeIixNTe3\r\n" +
+	" DhCVlUrSjV4BwcVcOF6+FF3Zo9Rpo1tFOeS9mPYQTnGdaSGsgeefOsk2Jz\r\n" +
+	" dA+L10TeYt9BgDfQNZtKdN1WO//KgIqXP7OdEFE4LjFYNcUxZQ4FADY+8=\r\n" +
+	"From: Joe SixPack <joe@football.example.com>\r\n" +
+	"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+	"Subject: Is dinner ready?\r\n" +
+	"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+	"Message-ID: <20030712040037.46341.5F8J@football.example.com>\r\n" +
+	"\r\n" +
+	"Hi.\r\n" +
+	"\r\n" +
+	"We lost the game.  Are you hungry yet?\r\n" +
+	"\r\n" +
+	"Joe.\r\n"
+
+var rfc8463Keys = StaticResolver{
+	"brisbane._domainkey.football.example.com": {"v=DKIM1; k=ed25519; " +
+		"p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="},
+	"test._domainkey.football.example.com": {"v=DKIM1; k=rsa; " +
+		"p=MIGfMA0GCSqGSIb3DQEBAQUAA4GNADCBiQKBgQDkHlOQoBTzWRiGs5V6NpP3idY6",
+		"Wk08a5qhdR6wy5bdOKb2jLQiY/J16JYi0Qvx/byYzCNb3W91y3FutACDfzwQ/BC/e/8u",
+		"BsCR+yz1Lxj+PL6lHvqMKrM3rG4hstT5QjvHO9PzoxZyVYLzBfO2EeC3Ip3G+2kryOTI",
+		"KT+l/K4w3QIDAQAB"},
+}
+
+// dkimMessage is signed with simple canonicalization by signDKIM
+const dkimMessage = "From: Alice <alice@example.com>\r\n" +
+	"To: Bob <bob@example.net>\r\n" +
+	"Subject: Lunch\r\n" +
+	"\r\n" +
+	"Are we still on for noon?\r\n"
+
+func TestVerifyDKIMRelaxed(t *testing.T) {
+	tests := []struct {
+		name     string
+		message  string
+		expected string
+	}{
+		{"signed", rfc8463Message, models.AuthResultPass},
+		{"whitespace changed in transit", strings.Replace(rfc8463Message,
+			"Subject: Is dinner ready?", "Subject:   Is  dinner ready?  ", 1),
+			models.AuthResultPass},
+		{"trailing lines added to the body", rfc8463Message + "\r\n\r\n",
+			models.AuthResultPass},
+		{"tampered header", strings.Replace(rfc8463Message,
+			"Subject: Is dinner ready?", "Subject: Is lunch ready?", 1),
+			models.AuthResultFail},
+		{"tampered body", strings.Replace(rfc8463Message, "We lost",
+			"We won", 1), models.AuthResultFail},
+	}
+
+	for _, test := range tests {
+		results := VerifyDKIM([]byte(test.message), rfc8463Keys)
+		if len(results) != 2 {
+			t.Errorf("%s: %d results, expected 2", test.name, len(results))
+			continue
+		}
+		for _, result := range results {
+			if result.Result != test.expected {
+				t.Errorf("%s: %s signature is %q (%s), expected %q", test.name,
+					result.Selector, result.Result, result.Reason, test.expected)
+			}
+		}
+	}
+}
+
+func TestVerifyDKIMSimple(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPublic, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := StaticResolver{
+		"rsa._domainkey.example.com": {"v=DKIM1; k=rsa; p=" +
+			base64.StdEncoding.EncodeToString(rsaPublic)},
+		"ed._domainkey.example.com": {"v=DKIM1; k=ed25519; p=" +
+			base64.StdEncoding.EncodeToString(edPublic)},
+		"revoked._domainkey.example.com": {"v=DKIM1; k=rsa; p="},
+	}
+	signedLength := len("Are we still on")
+
+	tests := []struct {
+		name      string
+		algorithm string
+		selector  string
+		key       crypto.Signer
+		length    int
+		tamper    func(string) string
+		expected  string
+	}{
+		{"rsa-sha256", "rsa-sha256", "rsa", rsaKey, -1, nil,
+			models.AuthResultPass},
+		{"ed25519-sha256", "ed25519-sha256", "ed", edKey, -1, nil,
+			models.AuthResultPass},
+		{"whitespace changed in transit", "rsa-sha256", "rsa", rsaKey, -1,
+			func(m string) string {
+				return strings.Replace(m, "Subject: Lunch", "Subject:  Lunch", 1)
+			}, models.AuthResultFail},
+		{"tampered header", "ed25519-sha256", "ed", edKey, -1,
+			func(m string) string {
+				return strings.Replace(m, "Subject: Lunch", "Subject: Dinner", 1)
+			}, models.AuthResultFail},
+		{"tampered body", "rsa-sha256", "rsa", rsaKey, -1,
+			func(m string) string {
+				return strings.Replace(m, "noon", "one", 1)
+			}, models.AuthResultFail},
+		{"text added after the signed length", "rsa-sha256", "rsa", rsaKey,
+			signedLength, func(m string) string {
+				return strings.Replace(m, "noon", "one", 1) + "Unsigned\r\n"
+			}, models.AuthResultPass},
+		{"tampered within the signed length", "ed25519-sha256", "ed", edKey,
+			signedLength, func(m string) string {
+				return strings.Replace(m, "still", "never", 1)
+			}, models.AuthResultFail},
+		{"body shorter than the signed length", "rsa-sha256", "rsa", rsaKey,
+			signedLength, func(m string) string {
+				return m[:strings.Index(m, "\r\n\r\n")+4] + "Are\r\n"
+			}, models.AuthResultFail},
+		{"rsa-sha1", "rsa-sha1", "rsa", rsaKey, -1, nil,
+			models.AuthResultPermError},
+		{"key type mismatch", "ed25519-sha256", "rsa", edKey, -1, nil,
+			models.AuthResultPermError},
+		{"revoked key", "rsa-sha256", "revoked", rsaKey, -1, nil,
+			models.AuthResultPermError},
+		{"no key published", "rsa-sha256", "missing", rsaKey, -1, nil,
+			models.AuthResultPermError},
+	}
+
+	for _, test := range tests {
+		message := signDKIM(t, dkimMessage, test.algorithm, test.selector,
+			test.key, test.length)
+		if test.tamper != nil {
+			message = test.tamper(message)
+		}
+		results := VerifyDKIM([]byte(message), resolver)
+		if len(results) != 1 {
+			t.Errorf("%s: %d results, expected 1", test.name, len(results))
+			continue
+		}
+		if results[0].Result != test.expected {
+			t.Errorf("%s: signature is %q (%s), expected %q", test.name,
+				results[0].Result, results[0].Reason, test.expected)
+		}
+	}
+}
+
+func TestVerifyDKIMSignatureLimit(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each signature's body hash matches, so each needs a lookup
+	signed := signDKIM(t, dkimMessage, "ed25519-sha256", "missing", key, -1)
+	signature := signed[:len(signed)-len(dkimMessage)]
+	message := strings.Repeat(signature, maxDKIMSignatures+3) + dkimMessage
+
+	lookups := 0
+	resolver := countingResolver{resolver: StaticResolver{}, lookups: &lookups}
+	results := VerifyDKIM([]byte(message), resolver)
+	if len(results) != maxDKIMSignatures {
+		t.Errorf("%d results, expected %d", len(results), maxDKIMSignatures)
+	}
+	if lookups != maxDKIMSignatures {
+		t.Errorf("%d lookups, expected %d", lookups, maxDKIMSignatures)
+	}
+}
+
+// countingResolver counts the lookups made through another resolver
+type countingResolver struct {
+	resolver DNSResolver
+	lookups  *int
+}
+
+func (r countingResolver) LookupTXT(name string) ([]string, error) {
+	*r.lookups++
+	return r.resolver.LookupTXT(name)
+}
+
+// signDKIM adds a DKIM-Signature to a message with simple canonicalization,
+// which signs the headers and body exactly as they are. The body is signed
+// up to length bytes, or entirely if length is negative
+func signDKIM(t *testing.T, message string, algorithm string,
+	selector string, key crypto.Signer, length int) string {
+
+	hash := crypto.SHA256
+	if algorithm == "rsa-sha1" {
+		hash = crypto.SHA1
+	}
+
+	split := strings.Index(message, "\r\n\r\n") + 2
+	headers, body := message[:split], message[split+2:]
+	tags := "v=1; a=" + algorithm + "; c=simple/simple; d=example.com; " +
+		"s=" + selector + "; h=From:To:Subject; "
+	if length >= 0 {
+		body = body[:length]
+		tags += "l=" + strconv.Itoa(length) + "; "
+	}
+	bodyHasher := hash.New()
+	bodyHasher.Write([]byte(body))
+	tags += "bh=" + base64.StdEncoding.EncodeToString(bodyHasher.Sum(nil)) +
+		"; b="
+
+	// Each signed header appears once, in the order they're listed
+	headerHasher := hash.New()
+	headerHasher.Write([]byte(headers + "DKIM-Signature: " + tags))
+	digest := headerHasher.Sum(nil)
+
+	var signature []byte
+	var err error
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		signature, err = key.Sign(rand.Reader, digest, crypto.Hash(0))
+	} else {
+		signature, err = key.Sign(rand.Reader, digest, hash)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "DKIM-Signature: " + tags +
+		base64.StdEncoding.EncodeToString(signature) + "\r\n" + message
+}
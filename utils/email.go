@@ -2,21 +2,26 @@ package utils
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"mime"
 	"mime/multipart"
 	"mime/quotedprintable"
 	"net/mail"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/anishmgoyal/calagora-admin/constants"
 	"github.com/anishmgoyal/calagora-admin/models"
-	"github.com/microcosm-cc/bluemonday"
 )
 
-const maxPartSize = 1024 * 1024 * 25
-
 // RawEmail contains fields for an email as it is being
 // parsed
 type RawEmail struct {
@@ -36,108 +41,152 @@ type headerInterface interface {
 	Get(key string) string
 }
 
-var policy *bluemonday.Policy
-
-func initEmail() {
-	policy = bluemonday.UGCPolicy()
+// ParseEmail attempts to parse an email. Returns an error if the message is
+// too malformed to be stored
+func ParseEmail(contents string) (*models.Email, error) {
+	return parseEmail(contents, 0)
 }
 
-// ParseEmail attempts to parse an email
-func ParseEmail(contents string) *models.Email {
+// parseEmail parses an email found depth levels deep in attached emails
+func parseEmail(contents string, depth int) (*models.Email, error) {
 	email := models.Email{}
 	reader := strings.NewReader(contents)
 	message, err := mail.ReadMessage(reader)
-	header := message.Header
-	received, err := time.Parse("Mon, 2 Jan 2006 15:04:05 -0700",
-		header.Get("Date"))
 	if err != nil {
-		received = time.Now()
-	}
-	email.Received = received
-	email.From = header.Get("From")
-	email.Subject = header.Get("Subject")
-
-	email.IsSpam = strings.Compare(
-		header.Get("X-SES-Spam-Verdict"), "PASS") != 0
-	email.IsVirus = strings.Compare(
-		header.Get("X-SES-Virus-Verdict"), "PASS") != 0
-
-	email.To = strings.Split(header.Get("To"), ",")
-	email.CC = strings.Split(header.Get("Cc"), ",")
-	email.BCC = strings.Split(header.Get("Bcc"), ",")
-
-	for _, slice := range [][]string{email.To, email.CC, email.BCC} {
-		for i, addr := range slice {
-			slice[i] = strings.TrimSpace(addr)
-			idxStart := strings.Index(addr, "<")
-			idxEnd := strings.Index(addr, ">")
-			if idxEnd > idxStart+5 {
-				slice[i] = addr[idxStart+1 : idxEnd]
-			}
-		}
+		return nil, errors.New("Malformed message header: " + err.Error())
 	}
-	/*for i, addr := range email.To {
-		email.To[i] = strings.TrimSpace(addr)
-		idxStart := strings.Index(addr, "<")
-		idxEnd := strings.Index(addr, ">")
-		if idxEnd > idxStart+5 {
-			email.To[i] = addr[idxStart+1 : idxEnd]
-		}
+	header := message.Header
+	// The time we received the email is set on delivery, if it's known
+	email.Received = time.Now()
+	if sent, err := ParseDate(header.Get("Date")); err == nil {
+		email.Sent = sent
+	}
+	email.RawSource = []byte(contents)
+	email.MessageID = strings.TrimSpace(header.Get("Message-Id"))
+	hash := sha256.Sum256([]byte(contents))
+	email.SourceHash = hex.EncodeToString(hash[:])
+
+	email.FromName, email.From = DecodeAddress(header.Get("From"))
+	if len(email.FromName) == 0 {
+		email.FromName = email.From
+	}
+	email.Subject = DecodeHeader(header.Get("Subject"))
+	email.Headers = ParseHeaderList(contents)
+	// SES records its spam and virus scans in headers which the sender could
+	// just as well have written, so they're only read from mail SES received.
+	// Mail from anywhere else hasn't been scanned
+	if parseAuthentication(&email, header) {
+		email.IsSpam = strings.Compare(
+			header.Get("X-SES-Spam-Verdict"), "PASS") != 0
+		email.IsVirus = strings.Compare(
+			header.Get("X-SES-Virus-Verdict"), "PASS") != 0
+	}
+
+	email.To = parseAddressHeader(header, "To")
+	email.CC = parseAddressHeader(header, "Cc")
+	email.BCC = parseAddressHeader(header, "Bcc")
+	email.ReplyTo = parseAddressHeader(header, "Reply-To")
+	email.Sender = parseAddressHeader(header, "Sender")
+	email.DeliveredTo = parseAddressHeader(header, "Delivered-To")
+
+	if err = parseBody(&email, header, message.Body, depth, true); err != nil {
+		return nil, err
 	}
-	for i, addr := range email.CC {
-		email.CC[i] = strings.TrimSpace(addr)
-		idxStart := strings.Index(addr, "<")
-		idxEnd := strings.Index(addr, ">")
-		if idxEnd > idxStart+5 {
-			email.CC[i] = addr[idxStart+1 : idxEnd]
-		}
-	}*/
+
+	// Mail with only an HTML body still needs text for searching, previews
+	// and quoting
+	if len(email.PlainText) == 0 && len(email.FormattedText) > 0 {
+		email.PlainText = HTMLToText(email.FormattedText)
+	}
+	markInlineAttachments(&email)
+
+	return &email, nil
+}
+
+// parseBody reads the body of an email, or the content of an S/MIME part,
+// into the email. root is set if it's the email's whole body, so that any
+// signature or encryption applies to the whole email
+func parseBody(email *models.Email, header headerInterface, body io.Reader,
+	depth int, root bool) error {
 
 	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
 	if err != nil {
 
-		// We don't parse quoted-printable later, so we need to
-		// parse it here.
-		reader := message.Body
-		switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
-		case "quoted-printable":
-			reader = quotedprintable.NewReader(reader)
-		}
-
 		// We may have only been given a formatted component
 		if strings.Compare(header.Get("Content-Type"), "text/html") == 0 {
-			parseFormattedText(&email, reader, header)
+			parseFormattedText(email, body, header)
 		} else {
-			parsePlainText(&email, reader, header)
+			parsePlainText(email, body, header)
 		}
 
 	} else {
 
-		if strings.HasPrefix(mediaType, "multipart/") {
-			parseMultipart(&email, message.Body, params)
+		if strings.Compare(mediaType, "multipart/signed") == 0 {
+			return parseSigned(email, body, header, params, depth, root)
+		} else if strings.HasPrefix(mediaType, "multipart/") {
+			return parseMultipart(email, body, params, depth)
+		} else if isSMIMEType(mediaType, params) {
+			parseSMIME(email, body, header, depth, root)
 		} else if strings.HasPrefix(mediaType, "text/") {
 
 			if strings.Compare(mediaType, "text/html") == 0 {
-				parsePlainText(&email, message.Body, header)
+				parseFormattedText(email, body, header)
+			} else if strings.Compare(mediaType, "text/calendar") == 0 {
+				bytes := readTextFromReader(email, body, header, mediaType)
+				email.Attachments = append(email.Attachments, models.Attachment{
+					ContentType: mediaType + "; charset=utf-8",
+					FileName:    "invite.ics",
+					RawData:     bytes,
+				})
+				parseCalendarEvents(email, bytes)
 			} else if len(email.PlainText) == 0 || strings.Compare(mediaType,
 				"text/plain") == 0 {
 
-				parsePlainText(&email, message.Body, header)
+				parsePlainText(email, body, header)
 			}
 
 		}
 	}
+	return nil
+}
 
-	return &email
+// markInlineAttachments marks attachments which are displayed as part of the
+// formatted text, which are images referenced by a cid: URL
+func markInlineAttachments(email *models.Email) {
+	if len(email.FormattedText) == 0 {
+		return
+	}
+	references := contentIDReferences(email.FormattedText)
+	for i := range email.Attachments {
+		attachment := &email.Attachments[i]
+		if len(attachment.ContentID) == 0 ||
+			strings.Compare(attachment.Disposition, "attachment") == 0 ||
+			!IsInlineImage(attachment.ContentType) {
+			continue
+		}
+		attachment.IsInline = references[attachment.ContentID]
+	}
 }
 
+// parseMultipart reads each part of a multipart body into the email. Returns
+// an error only if no parts could be found at all
 func parseMultipart(email *models.Email, body io.Reader,
-	params map[string]string) {
+	params map[string]string, depth int) error {
+
+	if len(params["boundary"]) == 0 {
+		return errors.New("Multipart body has no boundary")
+	}
 
 	mr := multipart.NewReader(body, params["boundary"])
-	for {
+	for numParts := 0; ; numParts++ {
 		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
+			if numParts == 0 {
+				return errors.New("Malformed multipart body: " + err.Error())
+			}
 			break
 		}
 
@@ -150,8 +199,20 @@ func parseMultipart(email *models.Email, body io.Reader,
 			parsePlainText(email, part, header)
 		} else {
 
-			if strings.HasPrefix(mediaType, "multipart/") {
-				parseMultipart(email, part, partParams)
+			if strings.Compare(mediaType, "multipart/signed") == 0 {
+				parseSigned(email, part, header, partParams, depth, false)
+
+			} else if strings.HasPrefix(mediaType, "multipart/") {
+				// A broken nested part shouldn't lose the rest of the message
+				parseMultipart(email, part, partParams, depth)
+
+			} else if isSMIMEType(mediaType, partParams) {
+				parseSMIME(email, part, header, depth, false)
+
+			} else if strings.Compare(mediaType, "message/rfc822") == 0 &&
+				depth < constants.MaxNestedEmails {
+
+				parseAttachedEmail(email, part, header, depth)
 
 			} else if isEmailBody && strings.Compare(mediaType, "text/plain") == 0 {
 				parsePlainText(email, part, header)
@@ -161,59 +222,259 @@ func parseMultipart(email *models.Email, body io.Reader,
 
 			} else {
 
-				bytes := readBytesFromReader(part, header)
-				if err == nil {
-					attachment := models.Attachment{
-						ContentType: mediaType,
-						FileName:    part.FileName(),
-						RawData:     bytes,
-					}
-					email.Attachments = append(email.Attachments, attachment)
+				disposition, _, _ := mime.ParseMediaType(
+					header.Get("Content-Disposition"))
+				fileName := part.FileName()
+				if len(fileName) == 0 &&
+					strings.Compare(mediaType, "text/calendar") == 0 {
+					fileName = "invite.ics"
+				}
+				attachment := models.Attachment{
+					ContentType: mediaType,
+					FileName:    fileName,
+					ContentID: strings.Trim(strings.TrimSpace(
+						header.Get("Content-Id")), "<>"),
+					Disposition: disposition,
+				}
+
+				isTNEF := strings.Compare(mediaType, "application/ms-tnef") == 0 ||
+					strings.EqualFold(part.FileName(), "winmail.dat")
+				if isTNEF || strings.HasPrefix(mediaType, "text/") {
+					// These are read into memory, as their contents are parsed
+					attachment.RawData, attachment.Warning = readBytesFromReader(
+						part, header, attachmentSizeLimit())
+				} else {
+					attachment.RawData, attachment.TempPath,
+						attachment.Warning = readAttachment(part, header)
+				}
+				if len(attachment.Warning) > 0 {
+					addWarning(email, partName(fileName, mediaType),
+						attachment.Warning)
+				}
+				if strings.HasPrefix(mediaType, "text/") {
+					// Text attachments are served as UTF-8, like the body
+					attachment.RawData, _ = decodeToUTF8(attachment.RawData,
+						partParams["charset"], mediaType)
+					attachment.ContentType = mediaType + "; charset=utf-8"
+				}
+				email.Attachments = append(email.Attachments, attachment)
+
+				if isTNEF {
+					parseTNEF(email, attachment.RawData)
+				} else if strings.Compare(mediaType, "text/calendar") == 0 {
+					parseCalendarEvents(email, attachment.RawData)
 				}
 
 			}
 		}
 	}
+	return nil
+}
+
+// parseAttachedEmail adds an attached email to the email's children. If it
+// can't be parsed, it's kept as an attachment instead
+func parseAttachedEmail(email *models.Email, part *multipart.Part,
+	header headerInterface, depth int) {
+
+	bytes, warning := readBytesFromReader(part, header, bodySizeLimit())
+	if len(warning) > 0 {
+		addWarning(email, partName(part.FileName(), "message/rfc822"), warning)
+	}
+	child, err := parseEmail(string(bytes), depth+1)
+	if err != nil {
+		fileName := part.FileName()
+		if len(fileName) == 0 {
+			fileName = "message.eml"
+		}
+		email.Attachments = append(email.Attachments, models.Attachment{
+			ContentType: "message/rfc822",
+			FileName:    fileName,
+			RawData:     bytes,
+		})
+		return
+	}
+
+	// Verdicts only apply to the message as it was received
+	child.IsSpam = email.IsSpam
+	child.IsVirus = email.IsVirus
+	child.IsUnauthenticated = false
+	email.Children = append(email.Children, *child)
+}
+
+// parseCalendarEvents adds the events described by a text/calendar part to
+// the email
+func parseCalendarEvents(email *models.Email, data []byte) {
+	events, err := ParseCalendar(data)
+	if err == nil {
+		email.Events = append(email.Events, events...)
+	}
 }
 
 func parsePlainText(email *models.Email, body io.Reader,
 	header headerInterface) {
 
-	bytes := readBytesFromReader(body, header)
+	bytes, warning := readBytesFromReader(body, header, bodySizeLimit())
+	if len(warning) > 0 {
+		addWarning(email, "text/plain body", warning)
+	}
+	// Files encoded in the text are kept as attachments. yEnc is binary, so
+	// this comes before any charset conversion
+	bytes = extractEncodedFiles(email, bytes)
+	bytes = decodeText(email, bytes, header, "text/plain")
 	email.PlainText = strings.TrimSpace(string(bytes))
 }
 
 func parseFormattedText(email *models.Email, body io.Reader,
 	header headerInterface) {
 
-	bytes := readBytesFromReader(body, header)
-	text := strings.TrimSpace(string(bytes))
+	bytes := readTextFromReader(email, body, header, "text/html")
+	// The original is stored, and only sanitized when shown
+	email.FormattedText = strings.TrimSpace(string(bytes))
+}
 
-	// A provided policy for sanitizing input
-	email.FormattedText = text //policy.Sanitize(text)
+// readTextFromReader reads a body part, converting it to UTF-8. The charset
+// of the first body part read is recorded on the email
+func readTextFromReader(email *models.Email, reader io.Reader,
+	header headerInterface, contentType string) []byte {
+
+	bytes, warning := readBytesFromReader(reader, header, bodySizeLimit())
+	if len(warning) > 0 {
+		addWarning(email, contentType+" body", warning)
+	}
+	return decodeText(email, bytes, header, contentType)
 }
 
-func readBytesFromReader(reader io.Reader, header headerInterface) []byte {
+// decodeText converts a body part to UTF-8, recording the charset on the
+// email if it's the first body part
+func decodeText(email *models.Email, bytes []byte, header headerInterface,
+	contentType string) []byte {
+
+	bytes, charset := decodeToUTF8(bytes, contentCharset(header), contentType)
+	if len(email.Charset) == 0 {
+		email.Charset = charset
+	}
+	return bytes
+}
+
+// readBytesFromReader reads and decodes a part, up to limit bytes. Returns a
+// warning if the part was truncated or could not be fully decoded, along
+// with whatever could be read
+func readBytesFromReader(reader io.Reader, header headerInterface,
+	limit int64) ([]byte, string) {
+
+	var buff bytes.Buffer
+	warning := copyPart(&buff, reader, header, limit)
+	return buff.Bytes(), warning
+}
 
-	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+// readAttachment decodes an attachment into a temporary file, so that large
+// attachments aren't held in memory. If no file can be created, the
+// attachment is read into memory instead. Returns the contents or the path
+// of the file, and a warning as readBytesFromReader does
+func readAttachment(reader io.Reader,
+	header headerInterface) ([]byte, string, string) {
+
+	file, err := ioutil.TempFile(constants.TempDirectory, "attachment-")
+	if err != nil {
+		fmt.Println("[PARSE] Reading attachment into memory: " + err.Error())
+		data, warning := readBytesFromReader(reader, header,
+			attachmentSizeLimit())
+		return data, "", warning
+	}
+
+	warning := copyPart(file, reader, header, attachmentSizeLimit())
+	if err = file.Close(); err != nil && len(warning) == 0 {
+		warning = "could not be saved: " + err.Error()
+	}
+	return nil, file.Name(), warning
+}
+
+// copyPart decodes a part into w, up to limit bytes. Returns a warning if the
+// part was truncated or could not be fully decoded
+func copyPart(w io.Writer, reader io.Reader, header headerInterface,
+	limit int64) string {
+
+	// Parts of a multipart body have already had quoted-printable decoded
+	switch strings.ToLower(strings.TrimSpace(
+		header.Get("Content-Transfer-Encoding"))) {
 	case "base64":
 		reader = base64.NewDecoder(base64.StdEncoding, reader)
+	case "quoted-printable":
+		reader = quotedprintable.NewReader(reader)
 	}
 
-	var buff bytes.Buffer
-	var slice = make([]byte, 1024)
-	total := 0
+	_, err := io.CopyN(w, reader, limit)
+	if err == io.EOF {
+		return ""
+	}
+	if err != nil {
+		return readWarning(err)
+	}
 
-	for total < maxPartSize {
-		n, err := reader.Read(slice)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return []byte{}
+	// Anything left over is cut off
+	if n, _ := io.ReadFull(reader, make([]byte, 1)); n > 0 {
+		return "truncated at " + formatSize(limit)
+	}
+	return ""
+}
+
+// readWarning describes an error reading a part
+func readWarning(err error) string {
+	if _, ok := err.(base64.CorruptInputError); ok {
+		return "bad base64"
+	}
+	if strings.HasPrefix(err.Error(), "quotedprintable") {
+		return "bad quoted-printable"
+	}
+	if err == io.ErrUnexpectedEOF {
+		return "incomplete part"
+	}
+	return "read error: " + err.Error()
+}
+
+// addWarning records a problem reading part of an email
+func addWarning(email *models.Email, part string, warning string) {
+	email.Warnings = append(email.Warnings, part+": "+warning)
+}
+
+// partName names a part in warnings, by its file name if it has one
+func partName(fileName string, mediaType string) string {
+	if len(fileName) > 0 {
+		return fileName
+	}
+	return mediaType
+}
+
+func bodySizeLimit() int64 {
+	return int64(constants.MaxBodySizeMB) * 1024 * 1024
+}
+
+func attachmentSizeLimit() int64 {
+	return int64(constants.MaxAttachmentSizeMB) * 1024 * 1024
+}
+
+// formatSize describes a size in bytes, e.g. "25MB"
+func formatSize(size int64) string {
+	switch {
+	case size >= 1024*1024 && size%(1024*1024) == 0:
+		return strconv.FormatInt(size/(1024*1024), 10) + "MB"
+	case size >= 1024 && size%1024 == 0:
+		return strconv.FormatInt(size/1024, 10) + "KB"
+	}
+	return strconv.FormatInt(size, 10) + " bytes"
+}
+
+// RemoveTempFiles deletes the temporary files holding an email's
+// attachments, and those of every email attached to it
+func RemoveTempFiles(email *models.Email) {
+	for i := range email.Attachments {
+		attachment := &email.Attachments[i]
+		if len(attachment.TempPath) > 0 {
+			os.Remove(attachment.TempPath)
+			attachment.TempPath = ""
 		}
-		total += n
-		buff.Write(slice[0:n])
 	}
-	return buff.Bytes()
+	for i := range email.Children {
+		RemoveTempFiles(&email.Children[i])
+	}
 }
@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/anishmgoyal/calagora-admin/constants"
+)
+
+func TestParseEmailScanVerdicts(t *testing.T) {
+	defer func(ids string) {
+		constants.AuthServIDs = ids
+	}(constants.AuthServIDs)
+	constants.AuthServIDs = "amazonses.com"
+
+	const ses = "Authentication-Results: amazonses.com; spf=pass " +
+		"smtp.mailfrom=a@example.com\r\n"
+	const smtp = "Authentication-Results: mx.calagora.com; none\r\n"
+	const clean = "X-SES-Spam-Verdict: PASS\r\nX-SES-Virus-Verdict: PASS\r\n"
+	const flagged = "X-SES-Spam-Verdict: FAIL\r\nX-SES-Virus-Verdict: FAIL\r\n"
+
+	tests := []struct {
+		name    string
+		headers string
+		spam    bool
+		virus   bool
+	}{
+		{"SES, clean", ses + clean, false, false},
+		{"SES, flagged", ses + flagged, true, true},
+		{"SES, not scanned", ses, true, true},
+		{"SMTP", smtp, false, false},
+		{"SMTP, forged flags", smtp + flagged, false, false},
+		{"SMTP, forged SES header and flags", smtp + ses + flagged,
+			false, false},
+		{"directory", "", false, false},
+		{"directory, forged flags", flagged, false, false},
+	}
+
+	for _, test := range tests {
+		email, err := ParseEmail(test.headers + "From: a@example.com\r\n" +
+			"Subject: Test\r\n\r\nBody\r\n")
+		if err != nil {
+			t.Errorf("%s: failed to parse: %s", test.name, err.Error())
+			continue
+		}
+		if email.IsSpam != test.spam || email.IsVirus != test.virus {
+			t.Errorf("%s: spam %t, virus %t, expected %t and %t", test.name,
+				email.IsSpam, email.IsVirus, test.spam, test.virus)
+		}
+	}
+}
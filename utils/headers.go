@@ -0,0 +1,188 @@
+package utils
+
+import (
+	"bytes"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+
+	"github.com/anishmgoyal/calagora-admin/models"
+)
+
+// encodedWord matches a single RFC 2047 encoded-word, e.g. =?UTF-8?B?...?=
+var encodedWord = regexp.MustCompile(`=\?[^?\s]+\?[bBqQ]\?[^?\s]*\?=`)
+
+var headerDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+// DecodeHeader decodes any RFC 2047 encoded-words in a header value. Plain
+// text between encoded-words is kept, while whitespace separating two
+// encoded-words is dropped. Words which can't be decoded, e.g. because of
+// an unknown charset, are left as they are
+func DecodeHeader(value string) string {
+	matches := encodedWord.FindAllStringIndex(value, -1)
+	if len(matches) == 0 {
+		return value
+	}
+
+	var buff bytes.Buffer
+	last := 0
+	prevDecoded := false
+	for _, match := range matches {
+		between := value[last:match[0]]
+		word := value[match[0]:match[1]]
+
+		decoded, err := headerDecoder.Decode(word)
+		if err != nil {
+			decoded = word
+		}
+		if !prevDecoded || err != nil || len(strings.TrimSpace(between)) > 0 {
+			buff.WriteString(between)
+		}
+		buff.WriteString(decoded)
+
+		prevDecoded = err == nil
+		last = match[1]
+	}
+	buff.WriteString(value[last:])
+	return buff.String()
+}
+
+// DecodeAddress splits an address header into its decoded display name and
+// its address. If the value can't be parsed as an address, the whole value
+// is decoded and returned as both
+func DecodeAddress(value string) (string, string) {
+	parser := mail.AddressParser{WordDecoder: headerDecoder}
+	address, err := parser.Parse(value)
+	if err == nil {
+		return address.Name, address.Address
+	}
+
+	// The parser gives up entirely if any word in the name fails to decode,
+	// so fall back to splitting on the angle brackets ourselves
+	start := strings.LastIndex(value, "<")
+	end := strings.LastIndex(value, ">")
+	if start > -1 && end > start {
+		name := strings.Trim(strings.TrimSpace(value[:start]), "\"")
+		return DecodeHeader(name), strings.TrimSpace(value[start+1 : end])
+	}
+	decoded := strings.TrimSpace(DecodeHeader(value))
+	return decoded, decoded
+}
+
+// ParseAddressList parses an address list header into recipients with
+// decoded display names. If the list as a whole is malformed, each entry is
+// parsed on its own so that one bad address doesn't lose the others
+func ParseAddressList(value string) []models.Recipient {
+	recipients := make([]models.Recipient, 0, 4)
+	if len(strings.TrimSpace(value)) == 0 {
+		return recipients
+	}
+
+	parser := mail.AddressParser{WordDecoder: headerDecoder}
+	addresses, err := parser.ParseList(value)
+	if err == nil {
+		for _, address := range addresses {
+			recipients = append(recipients, models.Recipient{
+				DisplayName:  address.Name,
+				EmailAddress: address.Address,
+			})
+		}
+		return recipients
+	}
+
+	for _, entry := range splitAddressList(value) {
+		name, address := DecodeAddress(entry)
+		if strings.Compare(name, address) == 0 {
+			name = ""
+		}
+		recipients = append(recipients, models.Recipient{
+			DisplayName:  name,
+			EmailAddress: address,
+		})
+	}
+	return recipients
+}
+
+// parseAddressHeader parses every instance of an address list header
+func parseAddressHeader(header mail.Header, key string) []models.Recipient {
+	recipients := make([]models.Recipient, 0, 4)
+	for _, value := range header[textproto.CanonicalMIMEHeaderKey(key)] {
+		recipients = append(recipients, ParseAddressList(value)...)
+	}
+	return recipients
+}
+
+// splitAddressList splits an address list on the commas between entries,
+// ignoring commas in quoted names, angle brackets and comments
+func splitAddressList(value string) []string {
+	entries := make([]string, 0, 4)
+	quoted := false
+	depth := 0
+	start := 0
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '<' || c == '(':
+			depth++
+		case (c == '>' || c == ')') && depth > 0:
+			depth--
+		case c == ',' && depth == 0:
+			entries = append(entries, value[start:i])
+			start = i + 1
+		}
+	}
+	entries = append(entries, value[start:])
+
+	nonEmpty := entries[:0]
+	for _, entry := range entries {
+		if len(strings.TrimSpace(entry)) > 0 {
+			nonEmpty = append(nonEmpty, entry)
+		}
+	}
+	return nonEmpty
+}
+
+// ParseHeaderList reads every header of a message, in order, including
+// repeated headers. Folded values are unfolded and encoded-words decoded
+func ParseHeaderList(contents string) []models.Header {
+	headers := make([]models.Header, 0, 32)
+	for _, line := range strings.Split(contents, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if len(line) == 0 {
+			// The header ends at the first empty line
+			break
+		}
+
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(headers) > 0 {
+				headers[len(headers)-1].Value += line
+			}
+			continue
+		}
+
+		idx := strings.Index(line, ":")
+		name := ""
+		if idx > 0 {
+			name = strings.TrimSpace(line[:idx])
+		}
+		if len(name) == 0 || strings.ContainsAny(name, " \t") {
+			// Not a header, e.g. an mbox "From " line
+			continue
+		}
+		headers = append(headers, models.Header{
+			Name:  name,
+			Value: line[idx+1:],
+		})
+	}
+
+	for i := range headers {
+		headers[i].Value = DecodeHeader(strings.TrimSpace(headers[i].Value))
+	}
+	return headers
+}
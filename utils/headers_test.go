@@ -0,0 +1,77 @@
+package utils
+
+import "testing"
+
+func TestDecodeHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected string
+	}{
+		{"plain text", "Quarterly report", "Quarterly report"},
+		{"B encoding", "=?UTF-8?B?w4lsw6hudQ==?=", "Élènu"},
+		{"Q encoding", "=?UTF-8?Q?caf=C3=A9_au_lait?=", "café au lait"},
+		{"lowercase encoding", "=?utf-8?q?na=C3=AFve?=", "naïve"},
+		{"ISO-8859-1", "=?ISO-8859-1?Q?Andr=E9?=", "André"},
+		{"Windows-1252", "=?windows-1252?Q?=93quoted=94?=", "“quoted”"},
+		{"Shift_JIS", "=?Shift_JIS?B?k/qWe4zq?=", "日本語"},
+		{"plain before encoded", "Re: =?UTF-8?B?w4lsw6hudQ==?=", "Re: Élènu"},
+		{"plain after encoded", "=?UTF-8?Q?caf=C3=A9?= menu", "café menu"},
+		{"plain between encoded",
+			"=?UTF-8?Q?caf=C3=A9?= and =?UTF-8?Q?th=C3=A9?=", "café and thé"},
+		{"adjacent encoded words drop whitespace",
+			"=?UTF-8?Q?caf?= \r\n =?UTF-8?Q?=C3=A9?=", "café"},
+		{"mixed charsets",
+			"=?ISO-8859-1?Q?Andr=E9?= =?UTF-8?B?4pyT?=", "André✓"},
+		{"unknown charset kept as is", "=?x-unknown?Q?abc?=",
+			"=?x-unknown?Q?abc?="},
+		{"unknown charset between decoded words",
+			"=?UTF-8?Q?a?= =?x-unknown?Q?b?= =?UTF-8?Q?c?=",
+			"a =?x-unknown?Q?b?= c"},
+		{"malformed base64 kept as is", "=?UTF-8?B?***?=", "=?UTF-8?B?***?="},
+		{"not an encoded word", "=?UTF-8?X?abc?=", "=?UTF-8?X?abc?="},
+	}
+
+	for _, test := range tests {
+		if actual := DecodeHeader(test.value); actual != test.expected {
+			t.Errorf("%s: DecodeHeader(%q) = %q, expected %q", test.name,
+				test.value, actual, test.expected)
+		}
+	}
+}
+
+func TestDecodeAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		display string
+		address string
+	}{
+		{"bare address", "jane@example.com", "", "jane@example.com"},
+		{"plain name", "Jane Doe <jane@example.com>", "Jane Doe",
+			"jane@example.com"},
+		{"quoted name", "\"Doe, Jane\" <jane@example.com>", "Doe, Jane",
+			"jane@example.com"},
+		{"B encoded name", "=?UTF-8?B?w4lsw6hudQ==?= <elenu@example.com>",
+			"Élènu", "elenu@example.com"},
+		{"Q encoded name", "=?ISO-8859-1?Q?Andr=E9_Martin?= <andre@example.com>",
+			"André Martin", "andre@example.com"},
+		{"mixed encoded and plain name",
+			"Dr. =?UTF-8?Q?Andr=C3=A9?= Martin <andre@example.com>",
+			"Dr. André Martin", "andre@example.com"},
+		{"unknown charset in name",
+			"=?x-unknown?Q?Jane?= <jane@example.com>", "=?x-unknown?Q?Jane?=",
+			"jane@example.com"},
+		{"unparseable value", "undisclosed-recipients:",
+			"undisclosed-recipients:", "undisclosed-recipients:"},
+	}
+
+	for _, test := range tests {
+		display, address := DecodeAddress(test.value)
+		if display != test.display || address != test.address {
+			t.Errorf("%s: DecodeAddress(%q) = %q, %q, expected %q, %q",
+				test.name, test.value, display, address, test.display,
+				test.address)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package utils
+
+import (
+	"html/template"
+	"mime"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/anishmgoyal/calagora-admin/constants"
+	"github.com/anishmgoyal/calagora-admin/models"
+	"github.com/microcosm-cc/bluemonday"
+	"golang.org/x/net/html"
+)
+
+// policy sanitizes email HTML, blocking remote content. remotePolicy does
+// the same, but lets remote content load
+var policy, remotePolicy *bluemonday.Policy
+
+// styleProperties are the inline styles kept in email HTML. None of them can
+// load remote content
+var styleProperties = []string{
+	"background-color", "border", "border-bottom", "border-collapse",
+	"border-color", "border-left", "border-radius", "border-right",
+	"border-spacing", "border-style", "border-top", "border-width", "color",
+	"display", "font", "font-family", "font-size", "font-style", "font-weight",
+	"height", "letter-spacing", "line-height", "list-style-type", "margin",
+	"margin-bottom", "margin-left", "margin-right", "margin-top", "max-width",
+	"min-width", "padding", "padding-bottom", "padding-left", "padding-right",
+	"padding-top", "text-align", "text-decoration", "text-transform",
+	"vertical-align", "white-space", "width",
+}
+
+func initEmail() {
+	policy = newHTMLPolicy(false)
+	remotePolicy = newHTMLPolicy(true)
+}
+
+// newHTMLPolicy builds the configured policy for sanitizing email HTML
+func newHTMLPolicy(allowRemote bool) *bluemonday.Policy {
+	if strings.Compare(constants.HTMLPolicy, "strict") == 0 {
+		return bluemonday.StrictPolicy()
+	}
+
+	p := bluemonday.UGCPolicy()
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	p.RequireNoReferrerOnLinks(true)
+	if constants.HTMLAllowStyles {
+		p.AllowStyles(styleProperties...).Globally()
+		if allowRemote {
+			p.AllowStyles("background", "background-image").Globally()
+		}
+	}
+	if !allowRemote {
+		// Images are only loaded from our own attachments
+		p.RewriteSrc(func(u *url.URL) {
+			if len(u.Scheme) > 0 || len(u.Host) > 0 {
+				*u = url.URL{}
+			}
+		})
+	}
+	return p
+}
+
+// SanitizeHTML makes email HTML safe to show. Remote content, which can be
+// used to track when an email is read, is blocked unless allowRemote is set.
+// Also determines if the HTML has remote content which was blocked
+func SanitizeHTML(html string, allowRemote bool) (template.HTML, bool) {
+	allowed := remotePolicy.Sanitize(html)
+	if allowRemote {
+		return template.HTML(allowed), false
+	}
+	blocked := policy.Sanitize(html)
+	return template.HTML(blocked), blocked != allowed
+}
+
+// contentIDAttributes are the attributes whose cid: URLs are loaded as
+// images. Links aren't rewritten, as opening an attachment of the sender's
+// choosing in the page would run whatever it holds
+var contentIDAttributes = map[string]bool{
+	"src": true, "background": true,
+}
+
+// inlineImageTypes are the image types which are safe to show in the page.
+// SVG is left out, as it can hold scripts
+var inlineImageTypes = map[string]bool{
+	"image/png": true, "image/jpeg": true, "image/pjpeg": true,
+	"image/gif": true, "image/webp": true, "image/bmp": true,
+	"image/x-icon": true, "image/vnd.microsoft.icon": true,
+}
+
+// IsInlineImage determines if an attachment is an image which can be shown
+// in the page, rather than only downloaded
+func IsInlineImage(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && inlineImageTypes[strings.ToLower(mediaType)]
+}
+
+// contentIDOf gets the Content-ID a cid: URL refers to, ignoring the case of
+// the scheme. The URL is meant to be escaped, but plenty of senders don't
+// bother. Returns false if the value isn't a cid: URL
+func contentIDOf(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if len(value) < 4 || !strings.EqualFold(value[:4], "cid:") {
+		return "", false
+	}
+	contentID := value[4:]
+	if unescaped, err := url.PathUnescape(contentID); err == nil {
+		contentID = unescaped
+	}
+	return contentID, true
+}
+
+// contentIDReferences finds every Content-ID referred to by a cid: URL in
+// HTML
+func contentIDReferences(source string) map[string]bool {
+	references := make(map[string]bool)
+	tokenizer := html.NewTokenizer(strings.NewReader(source))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			return references
+		}
+		if tokenType != html.StartTagToken &&
+			tokenType != html.SelfClosingTagToken {
+			continue
+		}
+		for _, attr := range tokenizer.Token().Attr {
+			if !contentIDAttributes[strings.ToLower(attr.Key)] {
+				continue
+			}
+			if contentID, ok := contentIDOf(attr.Val); ok {
+				references[contentID] = true
+			}
+		}
+	}
+}
+
+// RewriteContentIDs replaces cid: URLs in HTML with links to the matching
+// attachments, so that inline images load through the attachment route.
+// Only whole attribute values are replaced, and only if they match the
+// Content-ID of an image attachment exactly
+func RewriteContentIDs(source string, attachments []models.Attachment) string {
+	if !strings.Contains(strings.ToLower(source), "cid:") {
+		return source
+	}
+
+	links := make(map[string]string)
+	for _, attachment := range attachments {
+		if len(attachment.ContentID) == 0 ||
+			!IsInlineImage(attachment.ContentType) {
+			continue
+		}
+		links[attachment.ContentID] = "/attachment/" +
+			strconv.Itoa(attachment.ID) + "/" +
+			url.PathEscape(attachment.FileName)
+	}
+
+	var buff strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(source))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			return buff.String()
+		}
+		if tokenType != html.StartTagToken &&
+			tokenType != html.SelfClosingTagToken {
+			buff.Write(tokenizer.Raw())
+			continue
+		}
+
+		raw := string(tokenizer.Raw())
+		token := tokenizer.Token()
+		rewritten := false
+		for i, attr := range token.Attr {
+			if !contentIDAttributes[strings.ToLower(attr.Key)] {
+				continue
+			}
+			contentID, ok := contentIDOf(attr.Val)
+			if !ok {
+				continue
+			}
+			if link, ok := links[contentID]; ok {
+				token.Attr[i].Val = link
+				rewritten = true
+			}
+		}
+		if rewritten {
+			buff.WriteString(token.String())
+		} else {
+			buff.WriteString(raw)
+		}
+	}
+}
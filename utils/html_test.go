@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/anishmgoyal/calagora-admin/models"
+)
+
+func TestRewriteContentIDs(t *testing.T) {
+	attachments := []models.Attachment{
+		{ID: 1, ContentType: "image/png", FileName: "logo.png",
+			ContentID: "img1"},
+		{ID: 10, ContentType: "image/jpeg; name=photo.jpg",
+			FileName: "photo.jpg", ContentID: "img10"},
+		{ID: 2, ContentType: "text/html", FileName: "page.html",
+			ContentID: "page"},
+		{ID: 3, ContentType: "image/svg+xml", FileName: "icon.svg",
+			ContentID: "icon"},
+		{ID: 4, ContentType: "image/gif", FileName: "a b.gif",
+			ContentID: "x@y"},
+	}
+
+	tests := []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{"image source", `<img src="cid:img1">`,
+			`<img src="/attachment/1/logo.png">`},
+		{"longer ID with the same prefix", `<img src="cid:img10">`,
+			`<img src="/attachment/10/photo.jpg">`},
+		{"mixed case scheme", `<img src="Cid:img1">`,
+			`<img src="/attachment/1/logo.png">`},
+		{"escaped ID", `<img src="cid:x%40y">`,
+			`<img src="/attachment/4/a%20b.gif">`},
+		{"background", `<td background="cid:img1">`,
+			`<td background="/attachment/1/logo.png">`},
+		{"link to an image", `<a href="cid:img1">logo</a>`,
+			`<a href="cid:img1">logo</a>`},
+		{"link to a page", `<a href="cid:page">open</a>`,
+			`<a href="cid:page">open</a>`},
+		{"source which isn't an image", `<iframe src="cid:page"></iframe>`,
+			`<iframe src="cid:page"></iframe>`},
+		{"SVG image", `<img src="cid:icon">`, `<img src="cid:icon">`},
+		{"unknown ID", `<img src="cid:img2">`, `<img src="cid:img2">`},
+		{"text outside attributes", `<p>cid:img1</p>`, `<p>cid:img1</p>`},
+	}
+
+	for _, test := range tests {
+		actual := RewriteContentIDs(test.source, attachments)
+		if actual != test.expected {
+			t.Errorf("%s: RewriteContentIDs(%q) = %q, expected %q", test.name,
+				test.source, actual, test.expected)
+		}
+	}
+}
+
+func TestIsInlineImage(t *testing.T) {
+	tests := []struct {
+		contentType string
+		expected    bool
+	}{
+		{"image/png", true},
+		{"IMAGE/JPEG; name=\"a.jpg\"", true},
+		{"image/svg+xml", false},
+		{"text/html", false},
+		{"application/octet-stream", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		if actual := IsInlineImage(test.contentType); actual != test.expected {
+			t.Errorf("IsInlineImage(%q) = %t, expected %t", test.contentType,
+				actual, test.expected)
+		}
+	}
+}
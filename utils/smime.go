@@ -0,0 +1,353 @@
+package utils
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/mail"
+	"strings"
+
+	"github.com/anishmgoyal/calagora-admin/constants"
+	"github.com/anishmgoyal/calagora-admin/models"
+	"go.mozilla.org/pkcs7"
+)
+
+// smimeRoots are trusted to issue S/MIME signing certificates. If nil, no
+// signer is trusted
+var smimeRoots *x509.CertPool
+
+// smimeCertificate and smimeKey decrypt mail encrypted to the mailbox. If
+// nil, encrypted mail is kept as an attachment
+var smimeCertificate *x509.Certificate
+var smimeKey crypto.PrivateKey
+
+// oidEmailAddress is the emailAddress attribute some certificates keep in
+// their subject instead of a subject alternative name
+var oidEmailAddress = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+
+func initSMIME() {
+	if len(constants.SMIMETrustStore) == 0 {
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			fmt.Println("[SMIME] Failed to load system certificates: " +
+				err.Error())
+		}
+		smimeRoots = roots
+	} else {
+		pem, err := ioutil.ReadFile(constants.SMIMETrustStore)
+		if err != nil {
+			fmt.Println("[SMIME] Failed to load trust store: " + err.Error())
+		} else {
+			smimeRoots = x509.NewCertPool()
+			if !smimeRoots.AppendCertsFromPEM(pem) {
+				fmt.Println("[SMIME] No certificates found in trust store")
+			}
+		}
+	}
+
+	if len(constants.SMIMECertificate) == 0 || len(constants.SMIMEKey) == 0 {
+		return
+	}
+	pair, err := tls.LoadX509KeyPair(constants.SMIMECertificate,
+		constants.SMIMEKey)
+	if err != nil {
+		fmt.Println("[SMIME] Failed to load mailbox key: " + err.Error())
+		return
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		fmt.Println("[SMIME] Failed to load mailbox certificate: " +
+			err.Error())
+		return
+	}
+	smimeCertificate, smimeKey = cert, pair.PrivateKey
+}
+
+// isSMIMEType checks if a media type holds S/MIME signed or encrypted data
+func isSMIMEType(mediaType string, params map[string]string) bool {
+	if strings.Compare(mediaType, "application/pkcs7-mime") != 0 &&
+		strings.Compare(mediaType, "application/x-pkcs7-mime") != 0 {
+		return false
+	}
+	// Bundles of certificates have no content to show
+	return !strings.EqualFold(params["smime-type"], "certs-only")
+}
+
+// parseSMIME reads an application/pkcs7-mime part, which is either signed
+// data holding the content, or the content encrypted to the mailbox. If it
+// can't be read, it's kept as an attachment
+func parseSMIME(email *models.Email, body io.Reader, header headerInterface,
+	depth int, root bool) {
+
+	data, warning := readBytesFromReader(body, header, bodySizeLimit())
+	if len(warning) > 0 {
+		addWarning(email, "smime.p7m", warning)
+	}
+
+	p7, err := pkcs7.Parse(data)
+	if err != nil {
+		keepSMIME(email, data, "could not be read: "+smimeError(err))
+		return
+	}
+
+	content := p7.Content
+	if len(p7.Signers) > 0 {
+		verifySMIMEPart(email, p7, "smime.p7m", root)
+	} else {
+		if root {
+			email.IsEncrypted = true
+		} else {
+			email.SMIMEParts = append(email.SMIMEParts, "smime.p7m: encrypted")
+		}
+		if smimeKey == nil {
+			keepSMIME(email, data, "could not be decrypted: no mailbox key")
+			return
+		}
+		content, err = p7.Decrypt(smimeCertificate, smimeKey)
+		if err != nil {
+			keepSMIME(email, data, "could not be decrypted: "+smimeError(err))
+			return
+		}
+	}
+
+	if err = parseSMIMEContent(email, content, depth, root); err != nil {
+		keepSMIME(email, data, "content could not be read: "+err.Error())
+	}
+}
+
+// parseSigned reads a multipart/signed body. The first part is the content
+// and the second its signature, which is checked against the content exactly
+// as it was sent
+func parseSigned(email *models.Email, body io.Reader, header headerInterface,
+	params map[string]string, depth int, root bool) error {
+
+	data, warning := readBytesFromReader(body, header, bodySizeLimit())
+	if len(warning) > 0 {
+		addWarning(email, "multipart/signed body", warning)
+	}
+
+	protocol := strings.ToLower(params["protocol"])
+	parts := splitRawMultipart(data, params["boundary"])
+	if (strings.Compare(protocol, "application/pkcs7-signature") != 0 &&
+		strings.Compare(protocol, "application/x-pkcs7-signature") != 0) ||
+		len(parts) != 2 {
+
+		// Signatures we can't check are kept as attachments
+		return parseMultipart(email, bytes.NewReader(data), params, depth)
+	}
+
+	signature, err := mail.ReadMessage(bytes.NewReader(parts[1]))
+	if err == nil {
+		var sig []byte
+		sig, warning = readBytesFromReader(signature.Body, signature.Header,
+			bodySizeLimit())
+		if len(warning) > 0 {
+			addWarning(email, "smime.p7s", warning)
+		}
+
+		var p7 *pkcs7.PKCS7
+		if p7, err = pkcs7.Parse(sig); err == nil {
+			p7.Content = canonicalLineEndings(parts[0])
+			verifySMIMEPart(email, p7, "smime.p7s", root)
+		}
+	}
+	if err != nil {
+		signature := email
+		if !root {
+			signature = &models.Email{}
+		}
+		signature.SignatureStatus = models.SignatureInvalid
+		signature.SignatureError = "signature could not be read: " +
+			smimeError(err)
+		if !root {
+			addSMIMEPart(email, "smime.p7s", signature)
+		}
+	}
+
+	return parseSMIMEContent(email, parts[0], depth, root)
+}
+
+// parseSMIMEContent parses the MIME entity held in S/MIME data into the
+// email, as if it were the email's body if root is set
+func parseSMIMEContent(email *models.Email, content []byte, depth int,
+	root bool) error {
+
+	entity, err := mail.ReadMessage(bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	return parseBody(email, entity.Header, entity.Body, depth, root)
+}
+
+// keepSMIME stores S/MIME data which couldn't be read as an attachment
+func keepSMIME(email *models.Email, data []byte, warning string) {
+	email.Attachments = append(email.Attachments, models.Attachment{
+		ContentType: "application/pkcs7-mime",
+		FileName:    "smime.p7m",
+		Disposition: "attachment",
+		Warning:     warning,
+		RawData:     data,
+	})
+	addWarning(email, "smime.p7m", warning)
+}
+
+// verifySMIMEPart checks the S/MIME signature of an entity in an email. If
+// the entity isn't the email's whole body, the signature only speaks for that
+// part, so it's listed with the email's parts rather than recorded on it
+func verifySMIMEPart(email *models.Email, p7 *pkcs7.PKCS7, name string,
+	root bool) {
+
+	if root {
+		verifySMIME(email, p7)
+		return
+	}
+	signature := &models.Email{From: email.From}
+	verifySMIME(signature, p7)
+	addSMIMEPart(email, name, signature)
+}
+
+// addSMIMEPart lists the signature of one part of an email, marking the
+// email partially signed unless its whole body is signed too
+func addSMIMEPart(email *models.Email, name string, signature *models.Email) {
+	description := name + ": signature " + signature.SignatureStatus
+	if len(signature.Signer) > 0 {
+		description += ", signed by " + signature.Signer
+	}
+	if len(signature.SignatureError) > 0 {
+		description += " (" + signature.SignatureError + ")"
+	}
+	email.SMIMEParts = append(email.SMIMEParts, description)
+	if len(email.SignatureStatus) == 0 {
+		email.SignatureStatus = models.SignaturePartial
+	}
+}
+
+// verifySMIME checks an S/MIME signature, recording the signer and whether
+// they're trusted on the email
+func verifySMIME(email *models.Email, p7 *pkcs7.PKCS7) {
+	signer := p7.GetOnlySigner()
+	if signer == nil {
+		email.SignatureStatus = models.SignatureInvalid
+		email.SignatureError = "signer's certificate is missing"
+		return
+	}
+
+	addresses := certificateAddresses(signer)
+	email.Signer = signer.Subject.CommonName
+	if len(addresses) > 0 {
+		if len(email.Signer) > 0 {
+			email.Signer += " <" + addresses[0] + ">"
+		} else {
+			email.Signer = addresses[0]
+		}
+	}
+	email.SignerIssuer = signer.Issuer.CommonName
+	if len(email.SignerIssuer) == 0 {
+		email.SignerIssuer = signer.Issuer.String()
+	}
+	email.SignerExpires = signer.NotAfter
+
+	if err := p7.Verify(); err != nil {
+		email.SignatureStatus = models.SignatureInvalid
+		email.SignatureError = smimeError(err)
+		return
+	}
+
+	email.SignatureStatus = models.SignatureUntrusted
+	if smimeRoots == nil {
+		// Verifying with no roots would skip the chain entirely
+		email.SignatureError = "no trust store"
+		return
+	}
+	if err := p7.VerifyWithChain(smimeRoots); err != nil {
+		email.SignatureError = smimeError(err)
+		return
+	}
+	for _, address := range addresses {
+		if strings.EqualFold(address, email.From) {
+			email.SignatureStatus = models.SignatureValid
+			return
+		}
+	}
+	email.SignatureError = "certificate is not for the sender"
+}
+
+// certificateAddresses lists the email addresses a certificate was issued to
+func certificateAddresses(cert *x509.Certificate) []string {
+	addresses := append([]string{}, cert.EmailAddresses...)
+	for _, name := range cert.Subject.Names {
+		if !name.Type.Equal(oidEmailAddress) {
+			continue
+		}
+		if address, ok := name.Value.(string); ok {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// splitRawMultipart splits a multipart body into its parts, byte for byte.
+// The line break before each delimiter belongs to the delimiter. Returns nil
+// if the body has no closing delimiter
+func splitRawMultipart(data []byte, boundary string) [][]byte {
+	if len(boundary) == 0 {
+		return nil
+	}
+	delimiter := []byte("--" + boundary)
+
+	var parts [][]byte
+	start := -1
+	for offset := 0; offset < len(data); {
+		end := bytes.IndexByte(data[offset:], '\n')
+		if end < 0 {
+			end = len(data)
+		} else {
+			end += offset + 1
+		}
+
+		line := bytes.TrimRight(data[offset:end], " \t\r\n")
+		if bytes.HasPrefix(line, delimiter) {
+			rest := line[len(delimiter):]
+			isClose := string(rest) == "--"
+			if len(rest) == 0 || isClose {
+				if start >= 0 {
+					partEnd := offset
+					if partEnd > start && data[partEnd-1] == '\n' {
+						partEnd--
+					}
+					if partEnd > start && data[partEnd-1] == '\r' {
+						partEnd--
+					}
+					parts = append(parts, data[start:partEnd])
+				}
+				if isClose {
+					return parts
+				}
+				start = end
+			}
+		}
+		offset = end
+	}
+	return nil
+}
+
+// canonicalLineEndings converts line endings to CRLF, as signed content is
+// signed in that form
+func canonicalLineEndings(data []byte) []byte {
+	data = bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1)
+	return bytes.Replace(data, []byte("\n"), []byte("\r\n"), -1)
+}
+
+// smimeError describes a pkcs7 error in one line, without the package prefix
+func smimeError(err error) string {
+	message := strings.TrimPrefix(err.Error(), "pkcs7: ")
+	if idx := strings.Index(message, "\n"); idx > -1 {
+		message = message[:idx]
+	}
+	return message
+}
@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"time"
+)
+
+// snsMaxAge is how old a message may be when it arrives. SNS retries failed
+// deliveries for a few minutes, but older messages may be replayed captures
+const snsMaxAge = 10 * time.Minute
+
+// SNSMessage is the body of a request sent by Amazon SNS to an HTTP endpoint
+type SNSMessage struct {
+	Type             string `json:"Type"`
+	MessageID        string `json:"MessageId"`
+	Token            string `json:"Token"`
+	TopicArn         string `json:"TopicArn"`
+	Subject          string `json:"Subject"`
+	Message          string `json:"Message"`
+	Timestamp        string `json:"Timestamp"`
+	SignatureVersion string `json:"SignatureVersion"`
+	Signature        string `json:"Signature"`
+	SigningCertURL   string `json:"SigningCertURL"`
+	SubscribeURL     string `json:"SubscribeURL"`
+	UnsubscribeURL   string `json:"UnsubscribeURL"`
+}
+
+// SESNotification is the message SES publishes to SNS when mail is received
+type SESNotification struct {
+	NotificationType string `json:"notificationType"`
+	Mail             struct {
+		MessageID   string   `json:"messageId"`
+		Destination []string `json:"destination"`
+	} `json:"mail"`
+	Receipt struct {
+		Recipients []string `json:"recipients"`
+		Action     struct {
+			Type       string `json:"type"`
+			BucketName string `json:"bucketName"`
+			ObjectKey  string `json:"objectKey"`
+		} `json:"action"`
+	} `json:"receipt"`
+}
+
+// LoadCertificate reads a PEM encoded certificate from a file
+func LoadCertificate(path string) (*x509.Certificate, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(contents)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("No certificate found in " + path)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// VerifySNSMessage checks that an SNS message was signed by the holder of
+// the given certificate, and was sent recently
+func VerifySNSMessage(message *SNSMessage, cert *x509.Certificate) error {
+	now := time.Now()
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return errors.New("Signing certificate is not valid at this time")
+	}
+
+	// The timestamp is signed, so a replayed message can't be made newer
+	sent, err := time.Parse(time.RFC3339, message.Timestamp)
+	if err != nil {
+		return errors.New("Malformed timestamp")
+	}
+	if age := now.Sub(sent); age > snsMaxAge || age < -snsMaxAge {
+		return errors.New("Message timestamp is too old or in the future")
+	}
+
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("Signing certificate does not hold an RSA key")
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(message.Signature)
+	if err != nil {
+		return errors.New("Malformed signature")
+	}
+
+	data := []byte(message.stringToSign())
+	switch message.SignatureVersion {
+	case "1":
+		hash := sha1.Sum(data)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA1, hash[:], signature)
+	case "2":
+		hash := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature)
+	default:
+		return errors.New("Unknown signature version " +
+			message.SignatureVersion)
+	}
+}
+
+// stringToSign builds the canonical form of a message which SNS signs
+func (m *SNSMessage) stringToSign() string {
+	var fields [][2]string
+	if m.Type == "Notification" {
+		fields = [][2]string{
+			{"Message", m.Message},
+			{"MessageId", m.MessageID},
+			{"Subject", m.Subject},
+			{"Timestamp", m.Timestamp},
+			{"TopicArn", m.TopicArn},
+			{"Type", m.Type},
+		}
+	} else {
+		fields = [][2]string{
+			{"Message", m.Message},
+			{"MessageId", m.MessageID},
+			{"SubscribeURL", m.SubscribeURL},
+			{"Timestamp", m.Timestamp},
+			{"Token", m.Token},
+			{"TopicArn", m.TopicArn},
+			{"Type", m.Type},
+		}
+	}
+
+	str := ""
+	for _, field := range fields {
+		// Subject is the only field which may be left out entirely
+		if field[0] == "Subject" && len(field[1]) == 0 {
+			continue
+		}
+		str += field[0] + "\n" + field[1] + "\n"
+	}
+	return str
+}
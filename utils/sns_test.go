@@ -0,0 +1,156 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// testSNSCertificate creates a self signed certificate valid between
+// notBefore and notAfter
+func testSNSCertificate(t *testing.T, key *rsa.PrivateKey, notBefore time.Time,
+	notAfter time.Time) *x509.Certificate {
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.us-east-1.amazonaws.com"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template,
+		&key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// signSNSMessage signs a message as SNS would
+func signSNSMessage(t *testing.T, key *rsa.PrivateKey, message *SNSMessage) {
+	data := []byte(message.stringToSign())
+	var signature []byte
+	var err error
+	if message.SignatureVersion == "1" {
+		hash := sha1.Sum(data)
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA1, hash[:])
+	} else {
+		hash := sha256.Sum256(data)
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256,
+			hash[:])
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	message.Signature = base64.StdEncoding.EncodeToString(signature)
+}
+
+func TestVerifySNSMessage(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	cert := testSNSCertificate(t, key, now.Add(-time.Hour), now.Add(time.Hour))
+	expired := testSNSCertificate(t, key, now.Add(-2*time.Hour),
+		now.Add(-time.Hour))
+
+	notification := func() *SNSMessage {
+		return &SNSMessage{
+			Type:             "Notification",
+			MessageID:        "22b80b92-fdea-4c2c-8f9d-bdfb0c7bf324",
+			TopicArn:         "arn:aws:sns:us-east-1:123456789012:inbound",
+			Message:          `{"notificationType":"Received"}`,
+			Timestamp:        now.Format("2006-01-02T15:04:05.000Z"),
+			SignatureVersion: "2",
+		}
+	}
+
+	tests := []struct {
+		name    string
+		prepare func(m *SNSMessage) *x509.Certificate
+		valid   bool
+	}{
+		{"signature version 2", func(m *SNSMessage) *x509.Certificate {
+			signSNSMessage(t, key, m)
+			return cert
+		}, true},
+		{"signature version 1", func(m *SNSMessage) *x509.Certificate {
+			m.SignatureVersion = "1"
+			signSNSMessage(t, key, m)
+			return cert
+		}, true},
+		{"with subject", func(m *SNSMessage) *x509.Certificate {
+			m.Subject = "Amazon SES Email Receipt Notification"
+			signSNSMessage(t, key, m)
+			return cert
+		}, true},
+		{"subscription confirmation", func(m *SNSMessage) *x509.Certificate {
+			m.Type = "SubscriptionConfirmation"
+			m.Token = "2336412f37"
+			m.SubscribeURL = "https://sns.us-east-1.amazonaws.com/?Action=ConfirmSubscription"
+			signSNSMessage(t, key, m)
+			return cert
+		}, true},
+		{"tampered message", func(m *SNSMessage) *x509.Certificate {
+			signSNSMessage(t, key, m)
+			m.Message = `{"notificationType":"Bounce"}`
+			return cert
+		}, false},
+		{"signed by another key", func(m *SNSMessage) *x509.Certificate {
+			signSNSMessage(t, otherKey, m)
+			return cert
+		}, false},
+		{"expired certificate", func(m *SNSMessage) *x509.Certificate {
+			signSNSMessage(t, key, m)
+			return expired
+		}, false},
+		{"unknown signature version", func(m *SNSMessage) *x509.Certificate {
+			signSNSMessage(t, key, m)
+			m.SignatureVersion = "3"
+			return cert
+		}, false},
+		{"replayed message", func(m *SNSMessage) *x509.Certificate {
+			m.Timestamp = now.Add(-time.Hour).Format(time.RFC3339)
+			signSNSMessage(t, key, m)
+			return cert
+		}, false},
+		{"future timestamp", func(m *SNSMessage) *x509.Certificate {
+			m.Timestamp = now.Add(time.Hour).Format(time.RFC3339)
+			signSNSMessage(t, key, m)
+			return cert
+		}, false},
+		{"malformed timestamp", func(m *SNSMessage) *x509.Certificate {
+			m.Timestamp = "yesterday"
+			signSNSMessage(t, key, m)
+			return cert
+		}, false},
+	}
+
+	for _, test := range tests {
+		message := notification()
+		cert := test.prepare(message)
+		err := VerifySNSMessage(message, cert)
+		if test.valid && err != nil {
+			t.Errorf("%s: expected valid, got %v", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
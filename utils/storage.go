@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/anishmgoyal/calagora-admin/constants"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// FileStore is where original messages and attachments are kept
+type FileStore interface {
+	// Store saves a file under the given path, replacing any already there
+	Store(path string, contentType string, body io.ReadSeeker) error
+	// Load reads back a stored file
+	Load(path string) ([]byte, error)
+	// Delete removes a stored file. Removing a missing file is not an error
+	Delete(path string) error
+}
+
+// fileStore holds every stored file. It's chosen alongside the mail source,
+// so mail collected from a directory is stored in one too
+var fileStore FileStore
+
+func initStorage() {
+	switch constants.MailSourceType {
+	case "directory":
+		fileStore = NewDirectoryStore(constants.FileDirectory)
+	default:
+		fileStore = NewS3Store(constants.S3Bucket, constants.S3RegionString)
+	}
+}
+
+// S3Store keeps files in an S3 bucket
+type S3Store struct {
+	bucket string
+	svc    *s3.S3
+}
+
+// NewS3Store creates a store in the given bucket
+func NewS3Store(bucket string, region string) *S3Store {
+	return &S3Store{
+		bucket: bucket,
+		svc: s3.New(session.New(), &aws.Config{
+			Region: aws.String(region),
+		}),
+	}
+}
+
+// Store uploads a file to the bucket
+func (s *S3Store) Store(path string, contentType string,
+	body io.ReadSeeker) error {
+
+	_, err := s.svc.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(path),
+		ContentType: aws.String(contentType),
+		Body:        body,
+	})
+	return err
+}
+
+// Load downloads a file from the bucket
+func (s *S3Store) Load(path string) ([]byte, error) {
+	out, err := s.svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(path),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return ioutil.ReadAll(out.Body)
+}
+
+// Delete removes a file from the bucket
+func (s *S3Store) Delete(path string) error {
+	_, err := s.svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(path),
+	})
+	return err
+}
+
+// DirectoryStore keeps files in a local directory, for development machines
+// and tests without access to AWS
+type DirectoryStore struct {
+	root string
+}
+
+// NewDirectoryStore creates a store in the given directory
+func NewDirectoryStore(root string) *DirectoryStore {
+	return &DirectoryStore{root: root}
+}
+
+// Store writes a file into the directory. The file is written under a
+// temporary name first, so a failed write never leaves a partial file
+func (d *DirectoryStore) Store(path string, contentType string,
+	body io.ReadSeeker) error {
+
+	dest := d.path(path)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(filepath.Dir(dest), ".store-")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), dest)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+// Load reads a file from the directory
+func (d *DirectoryStore) Load(path string) ([]byte, error) {
+	return ioutil.ReadFile(d.path(path))
+}
+
+// Delete removes a file from the directory
+func (d *DirectoryStore) Delete(path string) error {
+	err := os.Remove(d.path(path))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// path maps a stored path onto the filesystem, refusing to leave the root
+// directory
+func (d *DirectoryStore) path(path string) string {
+	return filepath.Join(d.root, filepath.FromSlash(filepath.Clean("/"+path)))
+}
@@ -0,0 +1,320 @@
+package utils
+
+import (
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// linkPattern finds URLs and email addresses in plain text
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+|` +
+	`[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,}`)
+
+// blockBreaks are the line breaks around elements which start a new block
+var blockBreaks = map[atom.Atom]int{
+	atom.P: 2, atom.H1: 2, atom.H2: 2, atom.H3: 2, atom.H4: 2, atom.H5: 2,
+	atom.H6: 2, atom.Ul: 2, atom.Ol: 2, atom.Table: 2, atom.Blockquote: 2,
+	atom.Pre: 2, atom.Dl: 2, atom.Div: 1, atom.Tr: 1, atom.Li: 1, atom.Dt: 1,
+	atom.Dd: 1, atom.Section: 1, atom.Article: 1, atom.Header: 1,
+	atom.Footer: 1, atom.Address: 1, atom.Center: 1, atom.Form: 1,
+	atom.Caption: 1,
+}
+
+// textWriter renders HTML as plain text
+type textWriter struct {
+	buff     strings.Builder
+	breaks   int
+	space    bool
+	preDepth int
+	quotes   int
+	// lineQuotes is the quote depth of the last line written
+	lineQuotes int
+	lists      []int
+}
+
+// HTMLToText renders HTML as readable plain text. Links are followed by
+// their URL, list items are marked, and table cells are separated
+func HTMLToText(source string) string {
+	doc, err := html.Parse(strings.NewReader(source))
+	if err != nil {
+		return ""
+	}
+
+	w := &textWriter{}
+	w.render(doc)
+
+	lines := strings.Split(w.buff.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// write adds text, after any pending line break or space
+func (w *textWriter) write(text string) {
+	if len(text) == 0 {
+		return
+	}
+	switch {
+	case w.buff.Len() == 0:
+		w.buff.WriteString(strings.Repeat("> ", w.quotes))
+	case w.breaks > 0:
+		// Blank lines between quoted and unquoted text are left unquoted
+		blank := w.quotes
+		if w.lineQuotes < blank {
+			blank = w.lineQuotes
+		}
+		w.buff.WriteString(strings.Repeat("\n"+strings.Repeat("> ", blank),
+			w.breaks-1))
+		w.buff.WriteString("\n" + strings.Repeat("> ", w.quotes))
+	case w.space:
+		w.buff.WriteString(" ")
+	}
+	w.breaks, w.space = 0, false
+	w.lineQuotes = w.quotes
+	w.buff.WriteString(text)
+}
+
+// lineBreak ends the current line, leaving blank lines for new paragraphs
+func (w *textWriter) lineBreak(count int) {
+	if count > w.breaks {
+		w.breaks = count
+	}
+}
+
+func (w *textWriter) render(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		w.renderText(n.Data)
+		return
+	case html.ElementNode:
+	default:
+		w.renderChildren(n)
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Head, atom.Script, atom.Style, atom.Title, atom.Template:
+		return
+	case atom.Br:
+		if w.breaks < 2 {
+			w.breaks++
+		}
+		return
+	case atom.Hr:
+		w.lineBreak(2)
+		w.write("----")
+		w.lineBreak(2)
+		return
+	case atom.Img:
+		if alt := strings.TrimSpace(attribute(n, "alt")); len(alt) > 0 {
+			w.write("[" + alt + "]")
+		}
+		return
+	}
+
+	breaks := blockBreaks[n.DataAtom]
+	if (n.DataAtom == atom.Ul || n.DataAtom == atom.Ol) && len(w.lists) > 0 {
+		// Nested lists continue the outer list
+		breaks = 1
+	}
+	w.lineBreak(breaks)
+
+	switch n.DataAtom {
+	case atom.A:
+		w.renderLink(n)
+	case atom.Blockquote:
+		w.quotes++
+		w.renderChildren(n)
+		w.quotes--
+	case atom.Pre:
+		w.preDepth++
+		w.renderChildren(n)
+		w.preDepth--
+	case atom.Ul, atom.Ol:
+		w.lists = append(w.lists, 0)
+		if n.DataAtom == atom.Ol {
+			// Ordered lists count up from one
+			w.lists[len(w.lists)-1] = 1
+		}
+		w.renderChildren(n)
+		w.lists = w.lists[:len(w.lists)-1]
+	case atom.Li:
+		w.renderListItem(n)
+	case atom.Tr:
+		w.renderRow(n)
+	default:
+		w.renderChildren(n)
+	}
+
+	w.lineBreak(breaks)
+}
+
+func (w *textWriter) renderChildren(n *html.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		w.render(child)
+	}
+}
+
+func (w *textWriter) renderText(text string) {
+	if w.preDepth > 0 {
+		lines := strings.Split(text, "\n")
+		for i, line := range lines {
+			if i > 0 {
+				w.breaks++
+			}
+			w.write(line)
+		}
+		return
+	}
+
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		if len(text) > 0 {
+			w.space = true
+		}
+		return
+	}
+	if strings.TrimLeft(text, " \t\r\n\f") != text {
+		w.space = true
+	}
+	w.write(strings.Join(words, " "))
+	if strings.TrimRight(text, " \t\r\n\f") != text {
+		w.space = true
+	}
+}
+
+// renderLink writes the text of a link followed by its URL, unless the text
+// is already the URL
+func (w *textWriter) renderLink(n *html.Node) {
+	w.renderChildren(n)
+
+	href := strings.TrimSpace(attribute(n, "href"))
+	lower := strings.ToLower(href)
+	if !strings.HasPrefix(lower, "http:") && !strings.HasPrefix(lower, "https:") &&
+		!strings.HasPrefix(lower, "mailto:") {
+		return
+	}
+	text := strings.Join(strings.Fields(textContent(n)), " ")
+	if strings.EqualFold(text, href) ||
+		strings.EqualFold("mailto:"+text, href) {
+		return
+	}
+	w.space = len(text) > 0
+	w.write("<" + href + ">")
+}
+
+func (w *textWriter) renderListItem(n *html.Node) {
+	marker := "*"
+	depth := len(w.lists)
+	if depth > 0 && w.lists[depth-1] > 0 {
+		marker = strconv.Itoa(w.lists[depth-1]) + "."
+		w.lists[depth-1]++
+	}
+	if depth > 1 {
+		marker = strings.Repeat("  ", depth-1) + marker
+	}
+	w.write(marker)
+	w.space = true
+	w.renderChildren(n)
+}
+
+// renderRow writes the cells of a table row on one line. Empty cells, which
+// are common in tables used for layout, are skipped
+func (w *textWriter) renderRow(n *html.Node) {
+	first := true
+	for cell := n.FirstChild; cell != nil; cell = cell.NextSibling {
+		if cell.DataAtom != atom.Td && cell.DataAtom != atom.Th {
+			w.render(cell)
+			continue
+		}
+		if len(strings.TrimSpace(textContent(cell))) == 0 &&
+			!hasElement(cell, atom.Img) {
+			continue
+		}
+		if !first {
+			w.write(" |")
+			w.space = true
+		}
+		first = false
+		w.renderChildren(cell)
+	}
+}
+
+func attribute(n *html.Node, name string) string {
+	for _, attr := range n.Attr {
+		if strings.EqualFold(attr.Key, name) {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var text strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		text.WriteString(textContent(child))
+	}
+	return text.String()
+}
+
+func hasElement(n *html.Node, a atom.Atom) bool {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.DataAtom == a || hasElement(child, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// LinkifyText escapes plain text for display as HTML, making URLs and email
+// addresses into links
+func LinkifyText(text string) template.HTML {
+	var buff strings.Builder
+	last := 0
+	for _, match := range linkPattern.FindAllStringIndex(text, -1) {
+		start, end := match[0], trimLinkEnd(text[match[0]:match[1]])+match[0]
+		buff.WriteString(template.HTMLEscapeString(text[last:start]))
+		last = end
+
+		link := text[start:end]
+		href := link
+		switch {
+		case strings.Contains(link, "@") && !strings.Contains(link, "/"):
+			href = "mailto:" + link
+		case strings.HasPrefix(strings.ToLower(link), "www."):
+			href = "http://" + link
+		}
+		buff.WriteString(`<a href="` + template.HTMLEscapeString(href) +
+			`" target="_blank" rel="noopener noreferrer nofollow">` +
+			template.HTMLEscapeString(link) + "</a>")
+	}
+	buff.WriteString(template.HTMLEscapeString(text[last:]))
+	return template.HTML(buff.String())
+}
+
+// trimLinkEnd finds the length of a link without trailing punctuation, which
+// is more likely part of the sentence. Closing parentheses are kept if the
+// link opened them
+func trimLinkEnd(link string) int {
+	end := len(link)
+	for end > 0 {
+		c := link[end-1]
+		if c == ')' && strings.Count(link[:end], "(") >=
+			strings.Count(link[:end], ")") {
+			break
+		}
+		if !strings.ContainsRune(".,;:!?)'\"", rune(c)) {
+			break
+		}
+		end--
+	}
+	return end
+}
@@ -0,0 +1,108 @@
+package utils
+
+import "testing"
+
+func TestLinkifyText(t *testing.T) {
+	const attrs = `" target="_blank" rel="noopener noreferrer nofollow">`
+
+	tests := []struct {
+		name     string
+		text     string
+		expected string
+	}{
+		{"plain text", "Nothing to see", "Nothing to see"},
+		{"markup is escaped", `<script>alert("x")</script> & more`,
+			"&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt; &amp; more"},
+		{"URL", "See https://example.com/a?b=1&c=2 now",
+			`See <a href="https://example.com/a?b=1&amp;c=2` + attrs +
+				`https://example.com/a?b=1&amp;c=2</a> now`},
+		{"quotes end a URL", `https://example.com/"onmouseover="alert(1)`,
+			`<a href="https://example.com/` + attrs +
+				`https://example.com/</a>&#34;onmouseover=&#34;alert(1)`},
+		{"apostrophes in a URL are escaped",
+			"https://example.com/'onmouseover='alert(1)",
+			`<a href="https://example.com/&#39;onmouseover=&#39;alert(1)` +
+				attrs + `https://example.com/&#39;onmouseover=&#39;alert(1)` +
+				`</a>`},
+		{"angle brackets end a URL", "<https://example.com/x>",
+			`&lt;<a href="https://example.com/x` + attrs +
+				`https://example.com/x</a>&gt;`},
+		{"www", "Visit www.example.com.",
+			`Visit <a href="http://www.example.com` + attrs +
+				`www.example.com</a>.`},
+		{"trailing punctuation", "Is it https://example.com/page?",
+			`Is it <a href="https://example.com/page` + attrs +
+				`https://example.com/page</a>?`},
+		{"several trailing marks", `"https://example.com/a".`,
+			`&#34;<a href="https://example.com/a` + attrs +
+				`https://example.com/a</a>&#34;.`},
+		{"parentheses around a URL", "(see https://example.com/a)",
+			`(see <a href="https://example.com/a` + attrs +
+				`https://example.com/a</a>)`},
+		{"parentheses in a URL",
+			"https://en.wikipedia.org/wiki/Go_(game), it says",
+			`<a href="https://en.wikipedia.org/wiki/Go_(game)` + attrs +
+				`https://en.wikipedia.org/wiki/Go_(game)</a>, it says`},
+		{"email address", "Write to someone@example.com.",
+			`Write to <a href="mailto:someone@example.com` + attrs +
+				`someone@example.com</a>.`},
+		{"javascript URL", "javascript:alert(document.cookie)",
+			"javascript:alert(document.cookie)"},
+		{"javascript URL in a path",
+			"javascript:alert(1)//https://example.com",
+			`javascript:alert(1)//<a href="https://example.com` + attrs +
+				`https://example.com</a>`},
+		{"data URL", "data:text/html;base64,PHNjcmlwdD4=",
+			"data:text/html;base64,PHNjcmlwdD4="},
+		{"vbscript URL", "vbscript:msgbox(1)", "vbscript:msgbox(1)"},
+		{"other schemes", "ftp://example.com/file file:///etc/passwd",
+			"ftp://example.com/file file:///etc/passwd"},
+	}
+
+	for _, test := range tests {
+		actual := string(LinkifyText(test.text))
+		if actual != test.expected {
+			t.Errorf("%s: LinkifyText(%q) = %q, expected %q", test.name,
+				test.text, actual, test.expected)
+		}
+	}
+}
+
+func TestHTMLToText(t *testing.T) {
+	tests := []struct {
+		name     string
+		html     string
+		expected string
+	}{
+		{"paragraphs", "<p>One</p><p>Two</p>", "One\n\nTwo"},
+		{"entities are decoded", "<p>a &lt;b&gt; &amp; &quot;c&quot;</p>",
+			`a <b> & "c"`},
+		{"scripts and styles are dropped",
+			"<style>p{}</style><script>alert(1)</script><p>Text</p>", "Text"},
+		{"link", `<a href="https://example.com/a">Example</a>`,
+			"Example <https://example.com/a>"},
+		{"link to its own text",
+			`<a href="https://example.com/">https://example.com/</a>`,
+			"https://example.com/"},
+		{"mailto link", `<a href="mailto:a@example.com">a@example.com</a>`,
+			"a@example.com"},
+		{"javascript link", `<a href="javascript:alert(1)">Click</a>`,
+			"Click"},
+		{"data link", `<a href="data:text/html,x">Click</a>`, "Click"},
+		{"list", "<ul><li>One</li><li>Two</li></ul>", "* One\n* Two"},
+		{"ordered list", "<ol><li>One</li><li>Two</li></ol>", "1. One\n2. Two"},
+		{"quote", "<p>Reply</p><blockquote><p>Original</p></blockquote>",
+			"Reply\n\n> Original"},
+		{"table", "<table><tr><td>A</td><td></td><td>B</td></tr></table>",
+			"A | B"},
+		{"image", `<img src="x.png" alt="Logo">`, "[Logo]"},
+		{"preformatted", "<pre>a  b\nc</pre>", "a  b\nc"},
+	}
+
+	for _, test := range tests {
+		if actual := HTMLToText(test.html); actual != test.expected {
+			t.Errorf("%s: HTMLToText(%q) = %q, expected %q", test.name,
+				test.html, actual, test.expected)
+		}
+	}
+}
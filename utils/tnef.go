@@ -0,0 +1,422 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"mime"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/anishmgoyal/calagora-admin/models"
+)
+
+// TNEF (winmail.dat) is the format Outlook uses to send rich text mail. It is
+// a list of attributes, some of which hold MAPI property lists in turn
+
+const tnefSignature = 0x223E9F78
+
+// tnefLevelMessage marks attributes of the message, rather than of an
+// attachment
+const tnefLevelMessage = 1
+
+// TNEF attribute IDs, without their type in the high word
+const (
+	tnefAttBody           = 0x800C
+	tnefAttAttachData     = 0x800F
+	tnefAttAttachTitle    = 0x8010
+	tnefAttAttachRendData = 0x9002
+	tnefAttMsgProps       = 0x9003
+	tnefAttAttachment     = 0x9005
+)
+
+// MAPI property IDs
+const (
+	mapiBody             = 0x1000
+	mapiRTFCompressed    = 0x1009
+	mapiBodyHTML         = 0x1013
+	mapiAttachDataObj    = 0x3701
+	mapiAttachLongName   = 0x3707
+	mapiAttachMimeTag    = 0x370E
+	mapiAttachContentID  = 0x3712
+	mapiMultiValuedFlag  = 0x1000
+	mapiTypeUnicode      = 0x001F
+	mapiTypeObject       = 0x000D
+	mapiNamedPropertyMin = 0x8000
+)
+
+// rtfPrebuf seeds the dictionary used by compressed RTF
+const rtfPrebuf = "{\\rtf1\\ansi\\mac\\deff0\\deftab720{\\fonttbl;}" +
+	"{\\f0\\fnil \\froman \\fswiss \\fmodern \\fscript \\fdecor MS Sans " +
+	"SerifSymbolArialTimes New RomanCourier{\\colortbl\\red0\\green0\\blue0" +
+	"\r\n\\par \\pard\\plain\\f0\\fs20\\b\\i\\u\\tab\\tx"
+
+// TNEFAttachment is a file embedded in a TNEF stream
+type TNEFAttachment struct {
+	FileName    string
+	ContentType string
+	ContentID   string
+	Data        []byte
+}
+
+// TNEFMessage is everything of use found in a TNEF stream
+type TNEFMessage struct {
+	PlainText   string
+	HTML        string
+	RTF         []byte
+	Attachments []TNEFAttachment
+}
+
+// tnefReader reads little endian values out of a byte slice, remembering
+// whether it ever ran past the end
+type tnefReader struct {
+	data []byte
+	pos  int
+	bad  bool
+}
+
+func (r *tnefReader) bytes(n int) []byte {
+	if n < 0 || r.pos+n > len(r.data) {
+		r.bad = true
+		r.pos = len(r.data)
+		return []byte{}
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *tnefReader) uint16() uint16 {
+	b := r.bytes(2)
+	if len(b) < 2 {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(b)
+}
+
+func (r *tnefReader) uint32() uint32 {
+	b := r.bytes(4)
+	if len(b) < 4 {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (r *tnefReader) done() bool {
+	return r.bad || r.pos >= len(r.data)
+}
+
+// DecodeTNEF extracts the body and attached files from a TNEF stream
+func DecodeTNEF(data []byte) (*TNEFMessage, error) {
+	r := &tnefReader{data: data}
+	if r.uint32() != tnefSignature {
+		return nil, errors.New("Not a TNEF stream")
+	}
+	r.uint16() // Legacy key, unused
+
+	message := &TNEFMessage{}
+	var attachment *TNEFAttachment
+	for !r.done() {
+		level := r.bytes(1)
+		id := r.uint32() & 0xFFFF
+		length := r.uint32()
+		value := r.bytes(int(length))
+		r.uint16() // Checksum
+		if r.bad {
+			break
+		}
+
+		if len(level) == 1 && level[0] == tnefLevelMessage {
+			switch id {
+			case tnefAttBody:
+				message.PlainText = string(bytes.TrimRight(value, "\x00"))
+			case tnefAttMsgProps:
+				readTNEFMessageProps(message, value)
+			}
+			continue
+		}
+
+		switch id {
+		case tnefAttAttachRendData:
+			// Rendering data always comes first for each attachment
+			message.Attachments = append(message.Attachments, TNEFAttachment{})
+			attachment = &message.Attachments[len(message.Attachments)-1]
+		case tnefAttAttachTitle:
+			if attachment != nil && len(attachment.FileName) == 0 {
+				attachment.FileName = string(bytes.TrimRight(value, "\x00"))
+			}
+		case tnefAttAttachData:
+			if attachment != nil {
+				attachment.Data = value
+			}
+		case tnefAttAttachment:
+			if attachment != nil {
+				readTNEFAttachmentProps(attachment, value)
+			}
+		}
+	}
+
+	if r.bad && len(message.Attachments) == 0 && len(message.PlainText) == 0 &&
+		len(message.HTML) == 0 && len(message.RTF) == 0 {
+		return nil, errors.New("Truncated TNEF stream")
+	}
+	return message, nil
+}
+
+// parseTNEF adds the files and bodies embedded in a winmail.dat attachment to
+// the email. Bodies the email already has are kept as attachments instead
+func parseTNEF(email *models.Email, data []byte) {
+	message, err := DecodeTNEF(data)
+	if err != nil {
+		return
+	}
+
+	for _, embedded := range message.Attachments {
+		if len(embedded.Data) == 0 {
+			continue
+		}
+		contentType := embedded.ContentType
+		if len(contentType) == 0 {
+			contentType = mime.TypeByExtension(filepath.Ext(embedded.FileName))
+		}
+		if len(contentType) == 0 {
+			contentType = "application/octet-stream"
+		}
+		email.Attachments = append(email.Attachments, models.Attachment{
+			ContentType: contentType,
+			FileName:    embedded.FileName,
+			ContentID:   strings.Trim(embedded.ContentID, "<>"),
+			RawData:     embedded.Data,
+		})
+	}
+
+	if len(message.HTML) > 0 {
+		if len(email.FormattedText) == 0 {
+			email.FormattedText = strings.TrimSpace(message.HTML)
+		} else {
+			email.Attachments = append(email.Attachments, models.Attachment{
+				ContentType: "text/html; charset=utf-8",
+				FileName:    "body.html",
+				RawData:     []byte(message.HTML),
+			})
+		}
+	}
+	if len(message.PlainText) > 0 && len(email.PlainText) == 0 {
+		email.PlainText = strings.TrimSpace(message.PlainText)
+	}
+	if len(message.RTF) > 0 {
+		email.Attachments = append(email.Attachments, models.Attachment{
+			ContentType: "application/rtf",
+			FileName:    "body.rtf",
+			RawData:     message.RTF,
+		})
+	}
+}
+
+func readTNEFMessageProps(message *TNEFMessage, data []byte) {
+	for _, prop := range readMAPIProps(data) {
+		switch prop.id {
+		case mapiBody:
+			if len(message.PlainText) == 0 {
+				message.PlainText = prop.string()
+			}
+		case mapiBodyHTML:
+			message.HTML = prop.string()
+		case mapiRTFCompressed:
+			if rtf, err := DecompressRTF(prop.value); err == nil {
+				message.RTF = rtf
+			}
+		}
+	}
+}
+
+func readTNEFAttachmentProps(attachment *TNEFAttachment, data []byte) {
+	for _, prop := range readMAPIProps(data) {
+		switch prop.id {
+		case mapiAttachLongName:
+			attachment.FileName = prop.string()
+		case mapiAttachMimeTag:
+			attachment.ContentType = prop.string()
+		case mapiAttachContentID:
+			attachment.ContentID = prop.string()
+		case mapiAttachDataObj:
+			if len(attachment.Data) == 0 {
+				value := prop.value
+				if prop.kind == mapiTypeObject && len(value) >= 16 {
+					// Objects are prefixed by the interface they implement
+					value = value[16:]
+				}
+				attachment.Data = value
+			}
+		}
+	}
+}
+
+// mapiProp is a single MAPI property. Only the first value of multi-valued
+// properties is kept
+type mapiProp struct {
+	kind  uint16
+	id    uint16
+	value []byte
+}
+
+func (p mapiProp) string() string {
+	if p.kind == mapiTypeUnicode {
+		units := make([]uint16, 0, len(p.value)/2)
+		for i := 0; i+1 < len(p.value); i += 2 {
+			units = append(units, binary.LittleEndian.Uint16(p.value[i:]))
+		}
+		for len(units) > 0 && units[len(units)-1] == 0 {
+			units = units[:len(units)-1]
+		}
+		return string(utf16.Decode(units))
+	}
+	return string(bytes.TrimRight(p.value, "\x00"))
+}
+
+// mapiFixedSize gives the size of fixed length MAPI types, or 0 for types
+// whose values are prefixed by their length
+func mapiFixedSize(kind uint16) int {
+	switch kind {
+	case 0x0001, 0x0002, 0x0003, 0x0004, 0x000A, 0x000B:
+		return 4
+	case 0x0005, 0x0006, 0x0007, 0x0014, 0x0040:
+		return 8
+	case 0x0048:
+		return 16
+	}
+	return 0
+}
+
+func readMAPIProps(data []byte) []mapiProp {
+	r := &tnefReader{data: data}
+	count := r.uint32()
+	props := make([]mapiProp, 0, 16)
+
+	for i := uint32(0); i < count && !r.done(); i++ {
+		kind := r.uint16()
+		id := r.uint16()
+		if id >= mapiNamedPropertyMin {
+			r.bytes(16) // Property set GUID
+			if r.uint32() == 0 {
+				r.uint32()
+			} else {
+				nameLength := int(r.uint32())
+				r.bytes(nameLength + (4-nameLength%4)%4)
+			}
+		}
+
+		multiValued := kind&mapiMultiValuedFlag != 0
+		kind &^= mapiMultiValuedFlag
+
+		numValues := uint32(1)
+		size := mapiFixedSize(kind)
+		if multiValued || size == 0 {
+			numValues = r.uint32()
+		}
+
+		prop := mapiProp{kind: kind, id: id}
+		for j := uint32(0); j < numValues && !r.done(); j++ {
+			var value []byte
+			if size > 0 {
+				value = r.bytes(size)
+			} else {
+				length := int(r.uint32())
+				value = r.bytes(length)
+				r.bytes((4 - length%4) % 4)
+			}
+			if j == 0 {
+				prop.value = value
+			}
+		}
+		if r.bad {
+			break
+		}
+		props = append(props, prop)
+	}
+	return props
+}
+
+// DecompressRTF expands the compressed RTF Outlook stores message bodies in.
+// Returns an error if the RTF expands beyond the attachment size limit
+func DecompressRTF(data []byte) ([]byte, error) {
+	r := &tnefReader{data: data}
+	compressedSize := r.uint32()
+	rawSize := r.uint32()
+	compType := r.uint32()
+	r.uint32() // CRC
+	if r.bad {
+		return nil, errors.New("Truncated compressed RTF")
+	}
+
+	body := data[16:]
+	if int(compressedSize)-12 < len(body) && compressedSize >= 12 {
+		body = body[:compressedSize-12]
+	}
+
+	switch compType {
+	case 0x414C454D: // "MELA", stored without compression
+		if int(rawSize) < len(body) {
+			body = body[:rawSize]
+		}
+		return body, nil
+	case 0x75465A4C: // "LZFu"
+	default:
+		return nil, errors.New("Unknown RTF compression")
+	}
+
+	var dict [4096]byte
+	copy(dict[:], rtfPrebuf)
+	writePos := len(rtfPrebuf)
+
+	// The declared size comes from the sender, so it only sets the initial
+	// capacity as far as the limit allows
+	limit := attachmentSizeLimit()
+	capacity := int64(rawSize)
+	if capacity > limit {
+		capacity = limit
+	}
+	out := make([]byte, 0, capacity)
+	for pos := 0; pos < len(body); {
+		if int64(len(out)) > limit {
+			return nil, errors.New("Compressed RTF is larger than " +
+				formatSize(limit))
+		}
+
+		control := body[pos]
+		pos++
+		for bit := uint(0); bit < 8 && pos < len(body); bit++ {
+			if control&(1<<bit) == 0 {
+				out = append(out, body[pos])
+				dict[writePos] = body[pos]
+				writePos = (writePos + 1) % len(dict)
+				pos++
+				continue
+			}
+
+			if pos+1 >= len(body) {
+				return out, nil
+			}
+			ref := int(body[pos])<<8 | int(body[pos+1])
+			pos += 2
+			offset := ref >> 4
+			length := ref&0xF + 2
+			if offset == writePos {
+				// A reference to the write position marks the end
+				return out, nil
+			}
+			for i := 0; i < length; i++ {
+				c := dict[(offset+i)%len(dict)]
+				out = append(out, c)
+				dict[writePos] = c
+				writePos = (writePos + 1) % len(dict)
+			}
+		}
+	}
+	if int64(len(out)) > limit {
+		return nil, errors.New("Compressed RTF is larger than " +
+			formatSize(limit))
+	}
+	return out, nil
+}
@@ -0,0 +1,278 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"hash/crc32"
+	"mime"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/anishmgoyal/calagora-admin/models"
+)
+
+// uuBeginPattern matches the line starting a uuencoded file, e.g.
+// "begin 644 report.pdf". begin-base64 marks the base64 variant
+var uuBeginPattern = regexp.MustCompile(`^begin(-base64)? [0-7]{3,4} (.+)$`)
+
+// blockEnds holds the index of the last line which can end each kind of
+// encoded block. A block starting after it has no end, so it's rejected
+// without reading the rest of the body again
+type blockEnds struct {
+	uu     int
+	base64 int
+	yEnc   int
+}
+
+// encodedFile is a file found encoded in a plain text body
+type encodedFile struct {
+	name    string
+	data    []byte
+	warning string
+}
+
+// extractEncodedFiles finds files uuencoded or yEnc encoded in a plain text
+// body, adding them to the email as attachments. Returns the body with the
+// encoded files removed. Blocks which don't decode are left in the body
+func extractEncodedFiles(email *models.Email, body []byte) []byte {
+	if !bytes.Contains(body, []byte("begin")) {
+		return body
+	}
+
+	lines := bytes.SplitAfter(body, []byte("\n"))
+	ends := findBlockEnds(lines)
+	var text bytes.Buffer
+	for i := 0; i < len(lines); {
+		line := trimLineEnd(lines[i])
+
+		var file *encodedFile
+		next := i
+		if match := uuBeginPattern.FindSubmatch(line); match != nil {
+			isBase64 := len(match[1]) > 0
+			if (isBase64 && ends.base64 > i) || (!isBase64 && ends.uu > i) {
+				file, next = decodeUUBlock(lines, i+1, isBase64)
+			}
+			if file != nil {
+				file.name = string(match[2])
+			}
+		} else if bytes.HasPrefix(line, []byte("=ybegin ")) && ends.yEnc > i {
+			file, next = decodeYEncBlock(lines, i)
+		}
+
+		if file == nil {
+			text.Write(lines[i])
+			i++
+			continue
+		}
+
+		name := encodedFileName(file.name)
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if len(contentType) == 0 {
+			contentType = "application/octet-stream"
+		}
+		email.Attachments = append(email.Attachments, models.Attachment{
+			ContentType: contentType,
+			FileName:    name,
+			Disposition: "attachment",
+			Warning:     file.warning,
+			RawData:     file.data,
+		})
+		if len(file.warning) > 0 {
+			addWarning(email, name, file.warning)
+		}
+		i = next
+	}
+	return text.Bytes()
+}
+
+// findBlockEnds finds the last line which can end each kind of encoded block
+func findBlockEnds(lines [][]byte) blockEnds {
+	ends := blockEnds{uu: -1, base64: -1, yEnc: -1}
+	for i, line := range lines {
+		line = trimLineEnd(line)
+		switch {
+		case string(line) == "end":
+			ends.uu = i
+		case string(line) == "====":
+			ends.base64 = i
+		case bytes.HasPrefix(line, []byte("=yend")):
+			ends.yEnc = i
+		}
+	}
+	return ends
+}
+
+// decodeUUBlock decodes the lines of a uuencoded file, starting after its
+// begin line. Returns the file and the index of the line after its end, or
+// nil if the block is malformed
+func decodeUUBlock(lines [][]byte, start int,
+	isBase64 bool) (*encodedFile, int) {
+
+	var data bytes.Buffer
+	var encoded bytes.Buffer
+	for i := start; i < len(lines); i++ {
+		line := trimLineEnd(lines[i])
+
+		if isBase64 {
+			if string(line) == "====" {
+				decoded, err := base64.StdEncoding.DecodeString(encoded.String())
+				if err != nil {
+					return nil, start
+				}
+				return &encodedFile{data: decoded}, i + 1
+			}
+			if !isBase64Line(line) {
+				return nil, start
+			}
+			encoded.Write(line)
+			continue
+		}
+
+		if string(line) == "end" {
+			return &encodedFile{data: data.Bytes()}, i + 1
+		}
+		decoded, err := decodeUULine(line)
+		if err != nil {
+			return nil, start
+		}
+		data.Write(decoded)
+	}
+	// The file never ended, so it may not be a file at all
+	return nil, start
+}
+
+// isBase64Line checks if a line holds only base64 characters
+func isBase64Line(line []byte) bool {
+	for _, c := range line {
+		if !(c >= 'A' && c <= 'Z') && !(c >= 'a' && c <= 'z') &&
+			!(c >= '0' && c <= '9') && c != '+' && c != '/' && c != '=' {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeUULine decodes one line of a uuencoded file. The first character
+// gives the number of bytes on the line
+func decodeUULine(line []byte) ([]byte, error) {
+	if len(line) == 0 {
+		return nil, errors.New("Empty uuencoded line")
+	}
+	for _, c := range line {
+		if c < ' ' || c > '`' {
+			return nil, errors.New("Invalid uuencoded character")
+		}
+	}
+
+	// Encoders may strip trailing spaces, which decode to zero
+	length := int((line[0] - ' ') & 63)
+	chars := line[1:]
+	decoded := make([]byte, 0, length+2)
+	for i := 0; len(decoded) < length; i += 4 {
+		var group [4]byte
+		for j := range group {
+			if i+j < len(chars) {
+				group[j] = (chars[i+j] - ' ') & 63
+			}
+		}
+		decoded = append(decoded, group[0]<<2|group[1]>>4,
+			group[1]<<4|group[2]>>2, group[2]<<6|group[3])
+	}
+	return decoded[:length], nil
+}
+
+// decodeYEncBlock decodes a yEnc encoded file, starting at its =ybegin
+// line. Returns the file and the index of the line after its =yend line, or
+// nil if the block is malformed
+func decodeYEncBlock(lines [][]byte, start int) (*encodedFile, int) {
+	begin := yEncParams(trimLineEnd(lines[start]))
+	file := &encodedFile{name: begin["name"]}
+
+	expected, err := strconv.Atoi(begin["size"])
+	if err != nil {
+		return nil, start
+	}
+
+	i := start + 1
+	_, isPart := begin["part"]
+	if isPart && i < len(lines) &&
+		bytes.HasPrefix(trimLineEnd(lines[i]), []byte("=ypart ")) {
+
+		part := yEncParams(trimLineEnd(lines[i]))
+		first, err1 := strconv.Atoi(part["begin"])
+		last, err2 := strconv.Atoi(part["end"])
+		if err1 == nil && err2 == nil && last >= first {
+			expected = last - first + 1
+		}
+		i++
+	}
+
+	var data bytes.Buffer
+	for ; i < len(lines); i++ {
+		line := trimLineEnd(lines[i])
+		if bytes.HasPrefix(line, []byte("=yend")) {
+			file.data = data.Bytes()
+			end := yEncParams(line)
+
+			checksum := end["crc32"]
+			if isPart {
+				checksum = end["pcrc32"]
+			}
+			switch {
+			case isPart:
+				file.warning = "only part of a yEnc file"
+			case len(file.data) != expected:
+				file.warning = "incomplete yEnc file"
+			case len(checksum) > 0:
+				sum, err := strconv.ParseUint(checksum, 16, 32)
+				if err != nil || uint32(sum) != crc32.ChecksumIEEE(file.data) {
+					file.warning = "bad yEnc checksum"
+				}
+			}
+			return file, i + 1
+		}
+
+		for j := 0; j < len(line); j++ {
+			c := line[j]
+			if c == '=' && j+1 < len(line) {
+				j++
+				c = line[j] - 64
+			}
+			data.WriteByte(c - 42)
+		}
+	}
+	return nil, start
+}
+
+// yEncParams reads the keyword parameters of a yEnc control line. The name
+// is always last, and takes the rest of the line
+func yEncParams(line []byte) map[string]string {
+	params := make(map[string]string)
+	text := string(line)
+	if idx := strings.Index(text, " name="); idx > -1 {
+		params["name"] = strings.TrimSpace(text[idx+6:])
+		text = text[:idx]
+	}
+	for _, field := range strings.Fields(text)[1:] {
+		if idx := strings.Index(field, "="); idx > 0 {
+			params[field[:idx]] = field[idx+1:]
+		}
+	}
+	return params
+}
+
+// encodedFileName removes any directories from the name of an encoded file
+func encodedFileName(name string) string {
+	name = path.Base(strings.Replace(strings.TrimSpace(name), "\\", "/", -1))
+	if name == "." || name == "/" {
+		return "attachment"
+	}
+	return name
+}
+
+func trimLineEnd(line []byte) []byte {
+	return bytes.TrimRight(line, "\r\n")
+}